@@ -1,17 +1,154 @@
 package anki
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 )
 
+// DefaultProfile is the name anki gives the first profile
+const DefaultProfile = "User 1"
+
+// MediaConfig selects anki's media directory. Empty fields are discovered.
+type MediaConfig struct {
+	Dir     string // the media directory itself, skips the discovery
+	BaseDir string // anki's base directory holding prefs21.db and a folder per profile. defaults to ANKI_BASE or the platform's default
+	Profile string // profile name, defaults to the profile anki loaded last
+}
+
+// MediaDir returns the media directory of the default profile in the default base directory
 func MediaDir() (string, error) {
-	homeDir, err := os.UserHomeDir()
+	return FindMediaDir(MediaConfig{})
+}
+
+// FindMediaDir returns the media directory selected by config, which is <base dir>/<profile>/collection.media
+func FindMediaDir(config MediaConfig) (string, error) {
+	if config.Dir != "" {
+		return checkMediaDir(config.Dir)
+	}
+
+	baseDir := config.BaseDir
+	if baseDir == "" {
+		var err error
+		baseDir, err = FindBaseDir()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	profiles, lastLoaded, err := readProfiles(baseDir)
+	if err != nil {
+		return "", err
+	}
+
+	profile := config.Profile
+	switch {
+	case profile != "":
+		// profile names are case insensitive in anki
+		found := false
+		for _, name := range profiles {
+			if strings.EqualFold(name, profile) {
+				profile = name
+				found = true
+				break
+			}
+		}
+		if !found && len(profiles) > 0 {
+			return "", fmt.Errorf("anki profile %q not found in %s, the profiles are: %s", profile, baseDir, strings.Join(profiles, ", "))
+		}
+	case lastLoaded != "":
+		profile = lastLoaded
+	case len(profiles) == 1:
+		profile = profiles[0]
+	default:
+		profile = DefaultProfile
+	}
+
+	return checkMediaDir(filepath.Join(baseDir, profile, "collection.media"))
+}
+
+// Profiles lists the profile names in anki's base directory
+func Profiles(baseDir string) ([]string, error) {
+	profiles, _, err := readProfiles(baseDir)
+	return profiles, err
+}
+
+// FindBaseDir returns anki's base directory: ANKI_BASE when it's set, like anki itself does,
+// otherwise the first of the platform's default locations that exists
+func FindBaseDir() (string, error) {
+	if baseDir := os.Getenv("ANKI_BASE"); baseDir != "" {
+		return baseDir, nil
+	}
+
+	candidates, err := baseDirCandidates()
 	if err != nil {
 		return "", err
 	}
-	ankiMediaDir := filepath.Join(homeDir, "Library", "Application Support", "Anki2", "User 1", "collection.media")
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("anki base directory not found, looked in: %s. set ANKI_BASE or the media directory explicitly", strings.Join(candidates, ", "))
+}
+
+// baseDirCandidates returns the locations anki uses for its base directory on this platform
+func baseDirCandidates() ([]string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{filepath.Join(homeDir, "Library", "Application Support", "Anki2")}, nil
+	case "windows":
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			appData = filepath.Join(homeDir, "AppData", "Roaming")
+		}
+		return []string{filepath.Join(appData, "Anki2")}, nil
+	default:
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(homeDir, ".local", "share")
+		}
+		return []string{
+			filepath.Join(dataHome, "Anki2"),
+			// the flatpak keeps its data in the sandbox
+			filepath.Join(homeDir, ".var", "app", "net.ankiweb.Anki", "data", "Anki2"),
+		}, nil
+	}
+}
+
+// profileDirs lists the folders in the base directory that hold a collection, which are the profiles
+func profileDirs(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(baseDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("anki base directory missing: %v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(baseDir, entry.Name(), "collection.anki2")); err == nil {
+			profiles = append(profiles, entry.Name())
+		}
+	}
+	return profiles, nil
+}
+
+func checkMediaDir(ankiMediaDir string) (string, error) {
 	info, err := os.Stat(ankiMediaDir)
 	if err != nil {
 		return "", fmt.Errorf("anki media directory missing: %v", err)
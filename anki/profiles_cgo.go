@@ -0,0 +1,110 @@
+//go:build cgo
+
+package anki
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// globalProfile is the row of prefs21.db that holds anki's global settings instead of a profile
+const globalProfile = "_global"
+
+// readProfiles lists the profiles in prefs21.db, and returns the name of the profile anki loaded last.
+// It falls back to the profile folders when there is no prefs21.db.
+func readProfiles(baseDir string) ([]string, string, error) {
+	prefsPath := filepath.Join(baseDir, "prefs21.db")
+	if _, err := os.Stat(prefsPath); errors.Is(err, fs.ErrNotExist) {
+		profiles, err := profileDirs(baseDir)
+		return profiles, "", err
+	}
+
+	// read only, anki may have the database open
+	db, err := sql.Open("sqlite3", readOnlyURI(prefsPath))
+	if err != nil {
+		return nil, "", err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select name, data from profiles order by name")
+	if err != nil {
+		return nil, "", fmt.Errorf("read anki profiles from %s: %w", prefsPath, err)
+	}
+	defer rows.Close()
+
+	var profiles []string
+	var lastLoaded string
+	for rows.Next() {
+		var name string
+		var data []byte
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, "", err
+		}
+
+		if name == globalProfile {
+			lastLoaded = pickledString(data, "last_loaded_profile_name")
+			continue
+		}
+		profiles = append(profiles, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return profiles, lastLoaded, nil
+}
+
+// readOnlyURI returns the sqlite URI that opens the database at path read only. Everything but the slashes is
+// escaped, and a Windows path gets a leading slash, e.g. file:///C:/Users/..., because sqlite would read the drive
+// as the host otherwise.
+func readOnlyURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}).String()
+}
+
+// pickledString returns the string stored under key in a dict pickled by python, or "" when there is none.
+// It only understands the opcodes that follow a key in a pickled dict, which is enough for anki's settings.
+func pickledString(data []byte, key string) string {
+	index := bytes.Index(data, []byte(key))
+	if index < 0 {
+		return ""
+	}
+	rest := data[index+len(key):]
+
+	// skip the memo of the key
+	switch {
+	case len(rest) >= 1 && rest[0] == 0x94: // MEMOIZE
+		rest = rest[1:]
+	case len(rest) >= 2 && rest[0] == 'q': // BINPUT
+		rest = rest[2:]
+	case len(rest) >= 5 && rest[0] == 'r': // LONG_BINPUT
+		rest = rest[5:]
+	}
+
+	switch {
+	case len(rest) >= 2 && rest[0] == 0x8c: // SHORT_BINUNICODE
+		length := int(rest[1])
+		if len(rest) >= 2+length {
+			return string(rest[2 : 2+length])
+		}
+	case len(rest) >= 5 && rest[0] == 'X': // BINUNICODE
+		length := int(binary.LittleEndian.Uint32(rest[1:5]))
+		if len(rest) >= 5+length {
+			return string(rest[5 : 5+length])
+		}
+	}
+	return ""
+}
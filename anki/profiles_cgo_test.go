@@ -0,0 +1,118 @@
+//go:build cgo
+
+package anki
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// prefs21 blobs of the _global row, pickled by python like anki does
+var pickledPrefs = []struct {
+	name, want, blob string
+}{
+	{
+		name: "protocol 4",
+		want: "User 1",
+		blob: "800495a3000000000000007d94288c0763726561746564944a00f153658c08666972737452756e94898c0e73757070726573" +
+			"7355706461746594898c076c6173744d7367944b008c0b64656661756c744c616e67948c0564655f4445948c077570646174" +
+			"657394888c03766572944b008c026964944a15cd5b078c186c6173745f6c6f616465645f70726f66696c655f6e616d65948c" +
+			"06557365722031948c0a6e696768745f6d6f64659489752e",
+	},
+	{
+		name: "protocol 2",
+		want: "User 1",
+		blob: "80027d71002858070000006372656174656471014a00f153655808000000666972737452756e710289580e00000073757070" +
+			"7265737355706461746571038958070000006c6173744d736771044b00580b00000064656661756c744c616e677105580500" +
+			"000064655f44457106580700000075706461746573710788580300000076657271084b005802000000696471094a15cd5b07" +
+			"58180000006c6173745f6c6f616465645f70726f66696c655f6e616d65710a5806000000557365722031710b580a0000006e" +
+			"696768745f6d6f6465710c89752e",
+	},
+	{
+		name: "umlaut",
+		want: "Übung",
+		blob: "800495a3000000000000007d94288c0763726561746564944a00f153658c08666972737452756e94898c0e73757070726573" +
+			"7355706461746594898c076c6173744d7367944b008c0b64656661756c744c616e67948c0564655f4445948c077570646174" +
+			"657394888c03766572944b008c026964944a15cd5b078c186c6173745f6c6f616465645f70726f66696c655f6e616d65948c" +
+			"06c39c62756e67948c0a6e696768745f6d6f64659489752e",
+	},
+	{
+		name: "no profile loaded yet",
+		want: "",
+		blob: "8004952d000000000000007d94288c0763726561746564944b018c186c6173745f6c6f616465645f70726f66696c655f6e61" +
+			"6d65944e752e",
+	},
+	{
+		name: "profile data without the key",
+		want: "",
+		blob: "80049528000000000000007d94288c0763726561746564944b018c0773796e634b6579944e8c086175746f53796e63948875" +
+			"2e",
+	}}
+
+func TestPickledString(t *testing.T) {
+	for _, test := range pickledPrefs {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := hex.DecodeString(test.blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pickledString(data, "last_loaded_profile_name"); got != test.want {
+				t.Errorf("pickledString() = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// cut off blobs don't read past their end
+	data, _ := hex.DecodeString(pickledPrefs[0].blob)
+	for length := range len(data) {
+		pickledString(data[:length], "last_loaded_profile_name")
+	}
+}
+
+func TestReadOnlyURI(t *testing.T) {
+	got := readOnlyURI("/home/anna/Application Support/Anki2?#/prefs21.db")
+	if want := "file:///home/anna/Application%20Support/Anki2%3F%23/prefs21.db?mode=ro"; got != want {
+		t.Errorf("readOnlyURI() = %s, want %s", got, want)
+	}
+}
+
+func TestReadProfiles(t *testing.T) {
+	// a path with a space and an umlaut, like "Application Support" or a user name
+	baseDir := filepath.Join(t.TempDir(), "Application Support", "Änki2")
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(baseDir, "prefs21.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	global, _ := hex.DecodeString(pickledPrefs[2].blob)
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"create table profiles (name text primary key collate nocase, data blob not null)", nil},
+		{"insert into profiles values (?, ?)", []any{globalProfile, global}},
+		{"insert into profiles values (?, ?)", []any{"User 1", []byte{0x80, 0x04, 0x4e, 0x2e}}},
+		{"insert into profiles values (?, ?)", []any{"Übung", []byte{0x80, 0x04, 0x4e, 0x2e}}},
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	profiles, lastLoaded, err := readProfiles(baseDir)
+	if err != nil {
+		t.Fatalf("readProfiles() = %v", err)
+	}
+	if !slices.Equal(profiles, []string{"User 1", "Übung"}) || lastLoaded != "Übung" {
+		t.Errorf("readProfiles() = %q, %q, want both profiles and Übung loaded last", profiles, lastLoaded)
+	}
+}
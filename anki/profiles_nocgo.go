@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package anki
+
+// readProfiles lists the profile folders. Without cgo prefs21.db can't be read,
+// so the profile anki loaded last is unknown.
+func readProfiles(baseDir string) ([]string, string, error) {
+	profiles, err := profileDirs(baseDir)
+	return profiles, "", err
+}
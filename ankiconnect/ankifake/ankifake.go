@@ -0,0 +1,543 @@
+// Package ankifake is an in-memory AnkiConnect server, so that code talking to anki can be run without anki.
+//
+// Only the actions used by this repository are implemented, and they answer with the same
+// result shapes and error messages as the real AnkiConnect addon.
+package ankifake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const Version = 6
+
+// Note is a note stored in the fake collection
+type Note struct {
+	ID     int
+	Model  string
+	Deck   string
+	Fields map[string]string
+	Tags   []string
+}
+
+// Fake holds the collection state. It is safe for concurrent use.
+type Fake struct {
+	// APIKey, when set, has to be sent with every request like with AnkiConnect's apiKey setting
+	APIKey string
+
+	mu     sync.Mutex
+	nextID int
+	notes  map[int]*Note
+	decks  map[string]bool
+	models map[string][]string // key: model name, value: field names in order
+	media  map[string][]byte
+}
+
+// New returns an empty collection with anki's "Default" deck and "Basic" model
+func New() *Fake {
+	f := &Fake{
+		nextID: 1000000000000,
+		notes:  make(map[int]*Note),
+		decks:  make(map[string]bool),
+		models: make(map[string][]string),
+		media:  make(map[string][]byte),
+	}
+	f.AddDeck("Default")
+	f.AddModel("Basic", "Front", "Back")
+	return f
+}
+
+// Start serves the fake on a local port. The caller has to Close the returned server.
+func (f *Fake) Start() *httptest.Server {
+	return httptest.NewServer(f)
+}
+
+func (f *Fake) AddDeck(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.decks[name] = true
+}
+
+func (f *Fake) AddModel(name string, fields ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.models[name] = fields
+}
+
+// AddNote stores a note without any of the validation addNote does, and returns its ID
+func (f *Fake) AddNote(note Note) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.insertNote(note)
+}
+
+// Note returns a copy of the note with the given ID
+func (f *Fake) Note(id int) (Note, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	note, ok := f.notes[id]
+	if !ok {
+		return Note{}, false
+	}
+	return copyNote(note), true
+}
+
+// Notes returns copies of all notes, ordered by ID
+func (f *Fake) Notes() []Note {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	notes := make([]Note, 0, len(f.notes))
+	for _, id := range f.sortedIDs() {
+		notes = append(notes, copyNote(f.notes[id]))
+	}
+	return notes
+}
+
+func (f *Fake) DeleteNote(id int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.notes, id)
+}
+
+func (f *Fake) StoreMedia(filename string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.media[filename] = slices.Clone(data)
+}
+
+// Media returns the content of a media file
+func (f *Fake) Media(filename string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.media[filename]
+	return slices.Clone(data), ok
+}
+
+// MediaNames returns the names of all media files, sorted
+func (f *Fake) MediaNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := make([]string, 0, len(f.media))
+	for name := range f.media {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type request struct {
+	Action  string          `json:"action"`
+	Version int             `json:"version"`
+	Key     string          `json:"key"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Result any `json:"result"`
+	Error  any `json:"error"`
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// AnkiConnect answers plain GET requests with its version banner
+		fmt.Fprintf(w, "AnkiConnect v.%d", Version)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, response{Error: err.Error()})
+		return
+	}
+
+	if err := f.checkKey(req); err != nil {
+		writeJSON(w, response{Error: err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	result, err := f.dispatch(req)
+	f.mu.Unlock()
+
+	if err != nil {
+		writeJSON(w, response{Error: err.Error()})
+		return
+	}
+	writeJSON(w, response{Result: result})
+}
+
+// checkKey rejects a request without the API key. Like AnkiConnect, it applies to every action inside multi too.
+func (f *Fake) checkKey(req request) error {
+	if f.APIKey != "" && req.Key != f.APIKey && req.Action != "requestPermission" {
+		return errors.New("valid api key must be provided")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// dispatch runs a single action. f.mu has to be held.
+func (f *Fake) dispatch(req request) (any, error) {
+	switch req.Action {
+	case "version":
+		return Version, nil
+	case "requestPermission":
+		return map[string]any{"permission": "granted", "requireApiKey": f.APIKey != "", "version": Version}, nil
+	case "multi":
+		return f.multi(req.Params)
+	case "deckNames":
+		return sortedKeys(f.decks), nil
+	case "createDeck":
+		var params struct{ Deck string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		f.decks[params.Deck] = true
+		return 1, nil
+	case "modelNames":
+		return sortedKeys(f.models), nil
+	case "modelFieldNames":
+		var params struct{ ModelName string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		fields, ok := f.models[params.ModelName]
+		if !ok {
+			return nil, fmt.Errorf("model was not found: %s", params.ModelName)
+		}
+		return fields, nil
+	case "findNotes":
+		var params struct{ Query string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return f.findNotes(params.Query)
+	case "notesInfo":
+		var params struct{ Notes []int }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return f.notesInfo(params.Notes), nil
+	case "addNote":
+		var params struct{ Note noteParams }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return f.addNote(params.Note)
+	case "updateNoteFields":
+		var params struct{ Note noteParams }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, f.updateNoteFields(params.Note.ID, params.Note.Fields)
+	case "addTags", "removeTags":
+		var params struct {
+			Notes []int
+			Tags  string
+		}
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		f.changeTags(params.Notes, strings.Fields(params.Tags), req.Action == "addTags")
+		return nil, nil
+	case "storeMediaFile":
+		var params struct{ Filename, Data string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(params.Data)
+		if err != nil {
+			return nil, err
+		}
+		f.media[params.Filename] = data
+		return params.Filename, nil
+	case "retrieveMediaFile":
+		var params struct{ Filename string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		data, ok := f.media[params.Filename]
+		if !ok {
+			return false, nil
+		}
+		return base64.StdEncoding.EncodeToString(data), nil
+	case "getMediaFilesNames":
+		var params struct{ Pattern string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return f.mediaFileNames(params.Pattern)
+	case "deleteMediaFile":
+		var params struct{ Filename string }
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(f.media, params.Filename)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported action")
+	}
+}
+
+func (f *Fake) multi(rawParams json.RawMessage) (any, error) {
+	var params struct{ Actions []request }
+	if err := decode(rawParams, &params); err != nil {
+		return nil, err
+	}
+
+	results := make([]any, 0, len(params.Actions))
+	for _, action := range params.Actions {
+		var result any
+		err := f.checkKey(action)
+		if err == nil {
+			result, err = f.dispatch(action)
+		}
+
+		// like AnkiConnect, only version 6 and later actions get their errors reported per action
+		if action.Version >= 6 {
+			if err != nil {
+				results = append(results, response{Error: err.Error()})
+			} else {
+				results = append(results, response{Result: result})
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+type noteParams struct {
+	ID        int               `json:"id"`
+	DeckName  string            `json:"deckName"`
+	ModelName string            `json:"modelName"`
+	Fields    map[string]string `json:"fields"`
+	Tags      []string          `json:"tags"`
+}
+
+func (f *Fake) addNote(params noteParams) (int, error) {
+	if !f.decks[params.DeckName] {
+		return 0, fmt.Errorf("deck was not found: %s", params.DeckName)
+	}
+
+	fieldNames, ok := f.models[params.ModelName]
+	if !ok {
+		return 0, fmt.Errorf("model was not found: %s", params.ModelName)
+	}
+
+	fields := make(map[string]string, len(fieldNames))
+	for _, name := range fieldNames {
+		fields[name] = ""
+	}
+	for name, value := range params.Fields {
+		if _, ok := fields[name]; !ok {
+			return 0, fmt.Errorf("field was not found: %s", name)
+		}
+		fields[name] = value
+	}
+
+	// anki checks for duplicates on the first field of the model
+	firstField := fields[fieldNames[0]]
+	if strings.TrimSpace(firstField) == "" {
+		return 0, errors.New("cannot create note because it is empty")
+	}
+	for _, note := range f.notes {
+		if note.Model == params.ModelName && note.Fields[fieldNames[0]] == firstField {
+			return 0, errors.New("cannot create note because it is a duplicate")
+		}
+	}
+
+	return f.insertNote(Note{
+		Model:  params.ModelName,
+		Deck:   params.DeckName,
+		Fields: fields,
+		Tags:   params.Tags,
+	}), nil
+}
+
+func (f *Fake) updateNoteFields(id int, fields map[string]string) error {
+	note, ok := f.notes[id]
+	if !ok {
+		return fmt.Errorf("Note was not found: %d", id)
+	}
+
+	for name := range fields {
+		if _, ok := note.Fields[name]; !ok {
+			return fmt.Errorf("field was not found: %s", name)
+		}
+	}
+	for name, value := range fields {
+		note.Fields[name] = value
+	}
+
+	return nil
+}
+
+func (f *Fake) changeTags(ids []int, tags []string, add bool) {
+	for _, id := range ids {
+		note, ok := f.notes[id]
+		if !ok {
+			continue
+		}
+
+		for _, tag := range tags {
+			index := slices.IndexFunc(note.Tags, func(existing string) bool {
+				return strings.EqualFold(existing, tag)
+			})
+			switch {
+			case add && index < 0:
+				note.Tags = append(note.Tags, tag)
+			case !add && index >= 0:
+				note.Tags = slices.Delete(note.Tags, index, index+1)
+			}
+		}
+	}
+}
+
+func (f *Fake) findNotes(query string) ([]int, error) {
+	matcher, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for _, id := range f.sortedIDs() {
+		if matcher.match(f.notes[id]) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (f *Fake) notesInfo(ids []int) []any {
+	infos := make([]any, 0, len(ids))
+	for _, id := range ids {
+		note, ok := f.notes[id]
+		if !ok {
+			infos = append(infos, map[string]any{})
+			continue
+		}
+
+		fields := make(map[string]any, len(note.Fields))
+		for order, name := range f.fieldOrder(note) {
+			fields[name] = map[string]any{"value": note.Fields[name], "order": order}
+		}
+
+		infos = append(infos, map[string]any{
+			"noteId":    note.ID,
+			"modelName": note.Model,
+			"tags":      note.Tags,
+			"fields":    fields,
+			"cards":     []int{},
+		})
+	}
+	return infos
+}
+
+// fieldOrder returns the field names of a note in model order, or sorted when the model is unknown
+func (f *Fake) fieldOrder(note *Note) []string {
+	if fieldNames, ok := f.models[note.Model]; ok {
+		return fieldNames
+	}
+	return sortedKeys(note.Fields)
+}
+
+func (f *Fake) mediaFileNames(pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	names := []string{}
+	for _, name := range sortedKeys(f.media) {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// insertNote stores a note and assigns an ID if it doesn't have one. f.mu has to be held.
+func (f *Fake) insertNote(note Note) int {
+	if note.ID == 0 {
+		f.nextID++
+		note.ID = f.nextID
+	}
+	if note.Model == "" {
+		note.Model = "Basic"
+	}
+	if note.Deck == "" {
+		note.Deck = "Default"
+	}
+
+	stored := copyNote(&note)
+	for _, name := range f.models[note.Model] {
+		if _, ok := stored.Fields[name]; !ok {
+			stored.Fields[name] = ""
+		}
+	}
+	f.notes[note.ID] = &stored
+	return note.ID
+}
+
+func (f *Fake) sortedIDs() []int {
+	ids := make([]int, 0, len(f.notes))
+	for id := range f.notes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func copyNote(note *Note) Note {
+	copied := *note
+	copied.Tags = slices.Clone(note.Tags)
+	copied.Fields = make(map[string]string, len(note.Fields))
+	for name, value := range note.Fields {
+		copied.Fields[name] = value
+	}
+	return copied
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decode unmarshals action params, matching keys case-insensitively like encoding/json does
+func decode(raw json.RawMessage, target any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package ankifake
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matcher is a parsed anki search query.
+//
+// The supported subset of anki's search syntax is:
+//   - terms separated by spaces (and) or "or", grouped with parentheses and negated with "-"
+//   - tag:name, deck:name, note:model, nid:1,2,3 and field:value, where names may contain * and _ wildcards
+//   - plain text, which matches any field containing the text
+//   - a single "*" or an empty query, which matches every note
+type matcher interface {
+	match(note *Note) bool
+}
+
+type andMatcher []matcher
+
+func (m andMatcher) match(note *Note) bool {
+	for _, child := range m {
+		if !child.match(note) {
+			return false
+		}
+	}
+	return true
+}
+
+type orMatcher []matcher
+
+func (m orMatcher) match(note *Note) bool {
+	for _, child := range m {
+		if child.match(note) {
+			return true
+		}
+	}
+	return false
+}
+
+type notMatcher struct {
+	child matcher
+}
+
+func (m notMatcher) match(note *Note) bool {
+	return !m.child.match(note)
+}
+
+type funcMatcher func(note *Note) bool
+
+func (m funcMatcher) match(note *Note) bool {
+	return m(note)
+}
+
+func parseQuery(query string) (matcher, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	if len(tokens) == 0 {
+		return andMatcher{}, nil
+	}
+
+	result, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid search: unexpected %q", p.tokens[p.pos])
+	}
+	return result, nil
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) parseOr() (matcher, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	result := orMatcher{first}
+	for strings.EqualFold(p.peek(), "or") {
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, next)
+	}
+
+	if len(result) == 1 {
+		return first, nil
+	}
+	return result, nil
+}
+
+func (p *queryParser) parseAnd() (matcher, error) {
+	var result andMatcher
+	for {
+		token := p.peek()
+		if token == "" || token == ")" || strings.EqualFold(token, "or") {
+			break
+		}
+		if strings.EqualFold(token, "and") {
+			p.pos++
+			continue
+		}
+
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, next)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("invalid search: expected a search term")
+	}
+	return result, nil
+}
+
+func (p *queryParser) parseUnary() (matcher, error) {
+	token := p.peek()
+	p.pos++
+
+	switch {
+	case token == "-":
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notMatcher{child}, nil
+	case token == "(":
+		child, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("invalid search: missing )")
+		}
+		p.pos++
+		return child, nil
+	case strings.HasPrefix(token, "-") && len(token) > 1:
+		child, err := parseTerm(token[1:])
+		if err != nil {
+			return nil, err
+		}
+		return notMatcher{child}, nil
+	default:
+		return parseTerm(token)
+	}
+}
+
+// tokenize splits a query into terms and parentheses. Double quotes keep spaces inside a term.
+func tokenize(query string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+			current.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		case r == '(' || r == ')':
+			// a "-" directly before a group becomes its own token
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("invalid search: unterminated quote")
+	}
+	flush()
+
+	return tokens, nil
+}
+
+func parseTerm(term string) (matcher, error) {
+	if term == "*" {
+		return andMatcher{}, nil
+	}
+
+	key, value, hasKey := strings.Cut(term, ":")
+	if !hasKey {
+		pattern := wildcardRegex("*" + term + "*")
+		return funcMatcher(func(note *Note) bool {
+			for _, fieldValue := range note.Fields {
+				if pattern.MatchString(fieldValue) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+
+	switch strings.ToLower(key) {
+	case "tag":
+		// tag:name also matches child tags like name::child
+		pattern := wildcardRegex(value)
+		childPattern := wildcardRegex(value + "::*")
+		return funcMatcher(func(note *Note) bool {
+			for _, tag := range note.Tags {
+				if pattern.MatchString(tag) || childPattern.MatchString(tag) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case "deck":
+		pattern := wildcardRegex(value)
+		childPattern := wildcardRegex(value + "::*")
+		return funcMatcher(func(note *Note) bool {
+			return pattern.MatchString(note.Deck) || childPattern.MatchString(note.Deck)
+		}), nil
+	case "note":
+		pattern := wildcardRegex(value)
+		return funcMatcher(func(note *Note) bool {
+			return pattern.MatchString(note.Model)
+		}), nil
+	case "nid":
+		ids := make(map[int]bool)
+		for _, idString := range strings.Split(value, ",") {
+			id, err := strconv.Atoi(idString)
+			if err != nil {
+				return nil, fmt.Errorf("invalid search: bad note id %q", idString)
+			}
+			ids[id] = true
+		}
+		return funcMatcher(func(note *Note) bool {
+			return ids[note.ID]
+		}), nil
+	default:
+		// field:value matches the whole field content
+		pattern := wildcardRegex(value)
+		return funcMatcher(func(note *Note) bool {
+			for name, fieldValue := range note.Fields {
+				if strings.EqualFold(name, key) && pattern.MatchString(fieldValue) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+}
+
+// wildcardRegex converts an anki search pattern with * and _ wildcards into a case-insensitive regex
+func wildcardRegex(pattern string) *regexp.Regexp {
+	var builder strings.Builder
+	builder.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			builder.WriteString(".*")
+		case '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.MustCompile(builder.String())
+}
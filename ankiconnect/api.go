@@ -1,23 +1,33 @@
 package ankiconnect
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
 
 	"github.com/tidwall/gjson"
 )
 
-const ankiURI = "http://localhost:8765"
-const deckName = "B1_Wortliste_DTZ_Goethe"
+// The deck, note type and tag of generated notes, when nothing else is configured
+const (
+	DefaultDeck    = "B1_Wortliste_DTZ_Goethe"
+	DefaultModel   = "Basic (and reversed card)-7c609"
+	DefaultNoteTag = "gemini-generated"
+)
 
 type Note struct {
 	NoteID  int
+	Model   string            // the note type
 	Phrases map[string]Phrase // key: field name
+	Fields  map[string]string // raw values of all fields of the note, key: field name
+}
+
+// NewNote is a note to add. Empty Deck and Model use DefaultDeck and DefaultModel.
+type NewNote struct {
+	Deck   string
+	Model  string
+	Fields map[string]string
+	Tags   []string
 }
 
 type Phrase struct {
@@ -25,30 +35,35 @@ type Phrase struct {
 	Audio string // the audio field value. format is typically [sound:filename.mp3], and can also be empty.
 }
 
-// AddNote adds a note with the given fields, and returns the noteID
-func AddNote(fields map[string]string) (int, error) {
-	payload := map[string]any{
-		"action":  "addNote",
-		"version": 5,
-		"params": map[string]any{
-			"note": map[string]any{
-				"deckName":  deckName,
-				"modelName": "Basic (and reversed card)-7c609",
-				"fields":    fields,
-				"tags":      []string{"gemini-generated"},
-			},
+// AddNote adds a note, and returns the noteID
+func (c *Client) AddNote(ctx context.Context, note NewNote) (int, error) {
+	deck := note.Deck
+	if deck == "" {
+		deck = DefaultDeck
+	}
+	model := note.Model
+	if model == "" {
+		model = DefaultModel
+	}
+	tags := note.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	params := map[string]any{
+		"note": map[string]any{
+			"deckName":  deck,
+			"modelName": model,
+			"fields":    note.Fields,
+			"tags":      tags,
 		},
 	}
 
-	responseBody, err := sendRequest(payload)
+	responseBody, err := c.invoke(ctx, "addNote", params)
 	if err != nil {
 		return 0, err
 	}
 
-	if errMsg := gjson.GetBytes(responseBody, "error"); errMsg.Exists() && errMsg.String() != "" {
-		return 0, fmt.Errorf("anki connect error: %s", errMsg.String())
-	}
-
 	result := gjson.GetBytes(responseBody, "result")
 	if !result.Exists() {
 		return 0, errors.New("note ID not returned")
@@ -59,63 +74,61 @@ func AddNote(fields map[string]string) (int, error) {
 }
 
 // GetNote retrieves the fields of the note with the given noteID
-func GetNote(noteID int, fields map[string]string) (Note, error) {
-	payload := map[string]any{
-		"action":  "notesInfo",
-		"version": 5,
-		"params": map[string]any{
-			"notes": []int{noteID},
-		},
+func (c *Client) GetNote(ctx context.Context, noteID int, fields map[string]string) (Note, error) {
+	params := map[string]any{
+		"notes": []int{noteID},
 	}
 
-	responseBody, err := sendRequest(payload)
+	responseBody, err := c.invoke(ctx, "notesInfo", params)
 	if err != nil {
 		return Note{}, err
 	}
 
-	if errMsg := gjson.GetBytes(responseBody, "error"); errMsg.Exists() && errMsg.String() != "" {
-		return Note{}, fmt.Errorf("anki connect error: %s", errMsg.String())
-	}
-
 	noteResult := gjson.GetBytes(responseBody, "result.0")
-	if !noteResult.Exists() {
-		return Note{}, fmt.Errorf("note %d not found", noteID)
+	if !noteResult.Exists() || !noteResult.Get("noteId").Exists() {
+		return Note{}, fmt.Errorf("note %d: %w", noteID, ErrNoteNotFound)
 	}
 
+	return parseNote(noteResult, fields), nil
+}
+
+// parseNote converts a single notesInfo entry into a Note
+func parseNote(noteResult gjson.Result, fields map[string]string) Note {
 	result := Note{
-		NoteID:  noteID,
-		Phrases: make(map[string]Phrase),
+		NoteID: int(noteResult.Get("noteId").Int()),
+		Model:  noteResult.Get("modelName").String(),
+		Fields: make(map[string]string),
 	}
 
-	for field, audioField := range fields {
-		fieldValue := noteResult.Get(fmt.Sprintf("fields.%s.value", field)).String()
-		audioFieldValue := noteResult.Get(fmt.Sprintf("fields.%s.value", audioField)).String()
+	noteResult.Get("fields").ForEach(func(name, field gjson.Result) bool {
+		result.Fields[name.String()] = field.Get("value").String()
+		return true
+	})
 
-		result.Phrases[field] = Phrase{Value: fieldValue, Audio: audioFieldValue}
-	}
+	return result.MapPhrases(fields)
+}
 
-	return result, nil
+// MapPhrases returns the note with its Phrases taken from fields (key: field, value: audio field),
+// e.g. to use the field map of the note's type after fetching notes of several types
+func (n Note) MapPhrases(fields map[string]string) Note {
+	n.Phrases = make(map[string]Phrase, len(fields))
+	for field, audioField := range fields {
+		n.Phrases[field] = Phrase{Value: n.Fields[field], Audio: n.Fields[audioField]}
+	}
+	return n
 }
 
 // QueryNotes retrieves note IDs with the given anki query
-func QueryNotes(query string) ([]int, error) {
-	payload := map[string]any{
-		"action":  "findNotes",
-		"version": 5,
-		"params": map[string]any{
-			"query": query,
-		},
+func (c *Client) QueryNotes(ctx context.Context, query string) ([]int, error) {
+	params := map[string]any{
+		"query": query,
 	}
 
-	responseBody, err := sendRequest(payload)
+	responseBody, err := c.invoke(ctx, "findNotes", params)
 	if err != nil {
 		return nil, err
 	}
 
-	if errMsg := gjson.GetBytes(responseBody, "error"); errMsg.Exists() && errMsg.String() != "" {
-		return nil, fmt.Errorf("anki connect error: %s", errMsg.String())
-	}
-
 	gjsonResult := gjson.GetBytes(responseBody, "result")
 	if !gjsonResult.Exists() {
 		return nil, errors.New("gjsonResult doesn't exist")
@@ -130,21 +143,20 @@ func QueryNotes(query string) ([]int, error) {
 	return ids, nil
 }
 
-func UpdateNoteField(noteID int, fieldName, fieldValue string) error {
-	payload := map[string]any{
-		"action":  "updateNoteFields",
-		"version": 5,
-		"params": map[string]any{
-			"note": map[string]any{
-				"id": noteID,
-				"fields": map[string]any{
-					fieldName: fieldValue,
-				},
-			},
+func (c *Client) UpdateNoteField(ctx context.Context, noteID int, fieldName, fieldValue string) error {
+	return c.UpdateNoteFields(ctx, noteID, map[string]string{fieldName: fieldValue})
+}
+
+// UpdateNoteFields updates several fields of a note in one request
+func (c *Client) UpdateNoteFields(ctx context.Context, noteID int, fields map[string]string) error {
+	params := map[string]any{
+		"note": map[string]any{
+			"id":     noteID,
+			"fields": fields,
 		},
 	}
 
-	_, err := sendRequest(payload)
+	_, err := c.invoke(ctx, "updateNoteFields", params)
 	if err != nil {
 		return err
 	}
@@ -152,35 +164,31 @@ func UpdateNoteField(noteID int, fieldName, fieldValue string) error {
 	return nil
 }
 
-func AddNoteTag(noteID int, tag string) error {
-	payload := map[string]any{
-		"action":  "addTags",
-		"version": 5,
-		"params": map[string]any{
-			"notes": []int{noteID},
-			"tags":  tag,
-		},
+// ModelFieldNames returns the field names of a note type
+func (c *Client) ModelFieldNames(ctx context.Context, model string) ([]string, error) {
+	params := map[string]any{
+		"modelName": model,
 	}
 
-	_, err := sendRequest(payload)
+	responseBody, err := c.invoke(ctx, "modelFieldNames", params)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	var fieldNames []string
+	for _, name := range gjson.GetBytes(responseBody, "result").Array() {
+		fieldNames = append(fieldNames, name.String())
+	}
+	return fieldNames, nil
 }
 
-func RemoveNoteTag(noteID int, tag string) error {
-	payload := map[string]any{
-		"action":  "removeTags",
-		"version": 5,
-		"params": map[string]any{
-			"notes": []int{noteID},
-			"tags":  tag,
-		},
+func (c *Client) AddNoteTag(ctx context.Context, noteID int, tag string) error {
+	params := map[string]any{
+		"notes": []int{noteID},
+		"tags":  tag,
 	}
 
-	_, err := sendRequest(payload)
+	_, err := c.invoke(ctx, "addTags", params)
 	if err != nil {
 		return err
 	}
@@ -188,30 +196,43 @@ func RemoveNoteTag(noteID int, tag string) error {
 	return nil
 }
 
-func sendRequest(payload map[string]any) ([]byte, error) {
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("marshal request failed: %w", err)
+func (c *Client) RemoveNoteTag(ctx context.Context, noteID int, tag string) error {
+	params := map[string]any{
+		"notes": []int{noteID},
+		"tags":  tag,
 	}
 
-	response, err := http.Post(ankiURI, "application/json", bytes.NewReader(body))
+	_, err := c.invoke(ctx, "removeTags", params)
 	if err != nil {
-		return nil, fmt.Errorf("request anki connect: %w", err)
+		return err
 	}
-	defer response.Body.Close()
 
-	responseBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
-	}
+	return nil
+}
 
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("anki connect returned %d: %s", response.StatusCode, strings.TrimSpace(string(responseBody)))
-	}
+// AddNote adds a note using DefaultClient
+func AddNote(fields map[string]string) (int, error) {
+	return DefaultClient.AddNote(context.Background(), NewNote{Fields: fields, Tags: []string{DefaultNoteTag}})
+}
 
-	if errMsg := gjson.GetBytes(responseBody, "error"); errMsg.Exists() && errMsg.String() != "" {
-		return nil, fmt.Errorf("anki connect error: %s", errMsg.String())
-	}
+// GetNote retrieves a note using DefaultClient
+func GetNote(noteID int, fields map[string]string) (Note, error) {
+	return DefaultClient.GetNote(context.Background(), noteID, fields)
+}
+
+// QueryNotes runs an anki query using DefaultClient
+func QueryNotes(query string) ([]int, error) {
+	return DefaultClient.QueryNotes(context.Background(), query)
+}
 
-	return responseBody, nil
+func UpdateNoteField(noteID int, fieldName, fieldValue string) error {
+	return DefaultClient.UpdateNoteField(context.Background(), noteID, fieldName, fieldValue)
+}
+
+func AddNoteTag(noteID int, tag string) error {
+	return DefaultClient.AddNoteTag(context.Background(), noteID, tag)
+}
+
+func RemoveNoteTag(noteID int, tag string) error {
+	return DefaultClient.RemoveNoteTag(context.Background(), noteID, tag)
 }
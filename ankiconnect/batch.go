@@ -0,0 +1,136 @@
+package ankiconnect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/tidwall/gjson"
+)
+
+// maxBatchSize is the maximum number of actions sent in a single multi request.
+// Larger batches are split up transparently.
+const maxBatchSize = 100
+
+// Batch collects actions to be sent together through AnkiConnect's multi action.
+type Batch struct {
+	actions []map[string]any
+}
+
+// BatchResult is the outcome of a single action in a Batch.
+type BatchResult struct {
+	Result gjson.Result
+	Err    error
+}
+
+// Len returns the number of queued actions
+func (b *Batch) Len() int {
+	return len(b.actions)
+}
+
+// UpdateNoteFields queues an update of the given fields, and returns the index of the action
+func (b *Batch) UpdateNoteFields(noteID int, fields map[string]string) int {
+	return b.add("updateNoteFields", map[string]any{
+		"note": map[string]any{
+			"id":     noteID,
+			"fields": fields,
+		},
+	})
+}
+
+// AddNoteTag queues adding a tag to a note, and returns the index of the action
+func (b *Batch) AddNoteTag(noteID int, tag string) int {
+	return b.add("addTags", map[string]any{
+		"notes": []int{noteID},
+		"tags":  tag,
+	})
+}
+
+// RemoveNoteTag queues removing a tag from a note, and returns the index of the action
+func (b *Batch) RemoveNoteTag(noteID int, tag string) int {
+	return b.add("removeTags", map[string]any{
+		"notes": []int{noteID},
+		"tags":  tag,
+	})
+}
+
+func (b *Batch) add(action string, params map[string]any) int {
+	b.actions = append(b.actions, map[string]any{
+		"action": action,
+		// version 6 wraps each result in {"result": ..., "error": ...} so errors can be told apart
+		"version": 6,
+		"params":  params,
+	})
+	return len(b.actions) - 1
+}
+
+// SendBatch sends all queued actions, and returns one result per action in the order they were queued.
+// The returned error is only set when a request as a whole failed, errors of single actions are in the results.
+func (c *Client) SendBatch(ctx context.Context, batch *Batch) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, batch.Len())
+
+	for start := 0; start < batch.Len(); start += maxBatchSize {
+		end := min(start+maxBatchSize, batch.Len())
+		actions := make([]map[string]any, 0, end-start)
+		for _, action := range batch.actions[start:end] {
+			// AnkiConnect checks the key of every action inside multi, not only of the multi request itself
+			if c.apiKey != "" {
+				action = maps.Clone(action)
+				action["key"] = c.apiKey
+			}
+			actions = append(actions, action)
+		}
+
+		responseBody, err := c.invoke(ctx, "multi", map[string]any{"actions": actions})
+		if err != nil {
+			return results, err
+		}
+
+		multiResults := gjson.GetBytes(responseBody, "result").Array()
+		if len(multiResults) != len(actions) {
+			return results, fmt.Errorf("multi returned %d results for %d actions", len(multiResults), len(actions))
+		}
+
+		for index, multiResult := range multiResults {
+			var result BatchResult
+			if errMsg := multiResult.Get("error"); errMsg.Exists() && errMsg.String() != "" {
+				result.Err = newError(actions[index]["action"].(string), errMsg.String())
+			} else {
+				result.Result = multiResult.Get("result")
+			}
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// GetNotes retrieves the fields of all notes with the given noteIDs in one notesInfo request.
+// Notes that no longer exist are left out of the result.
+func (c *Client) GetNotes(ctx context.Context, noteIDs []int, fields map[string]string) ([]Note, error) {
+	if len(noteIDs) == 0 {
+		return nil, nil
+	}
+
+	responseBody, err := c.invoke(ctx, "notesInfo", map[string]any{"notes": noteIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	gjsonResult := gjson.GetBytes(responseBody, "result")
+	if !gjsonResult.Exists() {
+		return nil, errors.New("notesInfo result doesn't exist")
+	}
+
+	notes := make([]Note, 0, len(noteIDs))
+	for _, noteResult := range gjsonResult.Array() {
+		// notesInfo returns an empty object for notes that don't exist
+		if !noteResult.Get("noteId").Exists() {
+			continue
+		}
+		notes = append(notes, parseNote(noteResult, fields))
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,116 @@
+package ankiconnect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	DefaultURL     = "http://localhost:8765"
+	DefaultTimeout = 30 * time.Second
+)
+
+const apiVersion = 5
+
+// Config configures a Client. Zero values fall back to the defaults.
+type Config struct {
+	URL        string        // AnkiConnect base URL, defaults to DefaultURL
+	APIKey     string        // optional, only needed when AnkiConnect has an apiKey configured
+	Timeout    time.Duration // per request timeout, defaults to DefaultTimeout. ignored when HTTPClient is set
+	HTTPClient *http.Client
+}
+
+// Client talks to a single AnkiConnect instance.
+type Client struct {
+	url        string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// DefaultClient is used by the package level functions.
+var DefaultClient = NewClient(Config{})
+
+func NewClient(config Config) *Client {
+	url := config.URL
+	if url == "" {
+		url = DefaultURL
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		timeout := config.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		httpClient = &http.Client{Timeout: timeout}
+	}
+
+	return &Client{
+		url:        strings.TrimSuffix(url, "/"),
+		apiKey:     config.APIKey,
+		httpClient: httpClient,
+	}
+}
+
+// URL returns the AnkiConnect base URL the client sends requests to.
+func (c *Client) URL() string {
+	return c.url
+}
+
+// invoke sends a single action and returns the raw response body
+func (c *Client) invoke(ctx context.Context, action string, params map[string]any) ([]byte, error) {
+	payload := map[string]any{
+		"action":  action,
+		"version": apiVersion,
+	}
+	if params != nil {
+		payload["params"] = params
+	}
+	if c.apiKey != "" {
+		payload["key"] = c.apiKey
+	}
+
+	return c.sendRequest(ctx, action, payload)
+}
+
+func (c *Client) sendRequest(ctx context.Context, action string, payload map[string]any) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, classifyRequestError(err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, classifyStatus(response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	if errMsg := gjson.GetBytes(responseBody, "error"); errMsg.Exists() && errMsg.String() != "" {
+		return nil, newError(action, errMsg.String())
+	}
+
+	return responseBody, nil
+}
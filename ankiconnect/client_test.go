@@ -0,0 +1,226 @@
+package ankiconnect_test
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/ankiconnect/ankifake"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// newFake starts a fake AnkiConnect with the model and deck of the tests, and returns a client for it
+func newFake(t *testing.T, apiKey string) (*ankifake.Fake, *ankiconnect.Client) {
+	t.Helper()
+	fake := ankifake.New()
+	fake.APIKey = apiKey
+	fake.AddDeck("Deutsch")
+	fake.AddModel("Vokabel", "base_d", "base_a", "s1", "s1a")
+
+	server := fake.Start()
+	t.Cleanup(server.Close)
+	return fake, ankiconnect.NewClient(ankiconnect.Config{URL: server.URL, APIKey: apiKey})
+}
+
+func TestNotes(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFake(t, "")
+
+	noteID, err := client.AddNote(ctx, ankiconnect.NewNote{
+		Deck:   "Deutsch",
+		Model:  "Vokabel",
+		Fields: map[string]string{"base_d": "Haus", "s1": "Das Haus ist alt."},
+		Tags:   []string{"audio"},
+	})
+	if err != nil {
+		t.Fatalf("AddNote() = %v", err)
+	}
+
+	ids, err := client.QueryNotes(ctx, "tag:audio")
+	if err != nil || !slices.Equal(ids, []int{noteID}) {
+		t.Fatalf("QueryNotes() = %v, %v, want [%d]", ids, err, noteID)
+	}
+
+	fieldMap := map[string]string{"base_d": "base_a", "s1": "s1a"}
+	if err := client.UpdateNoteFields(ctx, noteID, map[string]string{"base_a": "[sound:haus.mp3]"}); err != nil {
+		t.Fatalf("UpdateNoteFields() = %v", err)
+	}
+	note, err := client.GetNote(ctx, noteID, fieldMap)
+	if err != nil {
+		t.Fatalf("GetNote() = %v", err)
+	}
+	if note.Model != "Vokabel" || note.Phrases["base_d"] != (ankiconnect.Phrase{Value: "Haus", Audio: "[sound:haus.mp3]"}) {
+		t.Errorf("GetNote() = %+v", note)
+	}
+	if note.Phrases["s1"].Value != "Das Haus ist alt." || note.Phrases["s1"].Audio != "" {
+		t.Errorf("GetNote() phrase s1 = %+v", note.Phrases["s1"])
+	}
+
+	fields, err := client.ModelFieldNames(ctx, "Vokabel")
+	if err != nil || !slices.Equal(fields, []string{"base_d", "base_a", "s1", "s1a"}) {
+		t.Errorf("ModelFieldNames() = %v, %v", fields, err)
+	}
+
+	if err := client.AddNoteTag(ctx, noteID, "audio-generated"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveNoteTag(ctx, noteID, "audio"); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := fake.Note(noteID)
+	if !slices.Equal(stored.Tags, []string{"audio-generated"}) {
+		t.Errorf("tags = %v, want [audio-generated]", stored.Tags)
+	}
+
+	// notes that were deleted are left out of GetNotes
+	fake.DeleteNote(noteID)
+	notes, err := client.GetNotes(ctx, []int{noteID}, fieldMap)
+	if err != nil || len(notes) != 0 {
+		t.Errorf("GetNotes() of a deleted note = %v, %v, want none", notes, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFake(t, "")
+	haus := ankiconnect.NewNote{Deck: "Deutsch", Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}}
+	if _, err := client.AddNote(ctx, haus); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"duplicate note", func() error { _, err := client.AddNote(ctx, haus); return err }, ankiconnect.ErrDuplicateNote},
+		{"missing deck", func() error {
+			_, err := client.AddNote(ctx, ankiconnect.NewNote{Deck: "Englisch", Model: "Vokabel", Fields: map[string]string{"base_d": "Hund"}})
+			return err
+		}, ankiconnect.ErrDeckNotFound},
+		{"missing model", func() error { _, err := client.ModelFieldNames(ctx, "Cloze"); return err }, ankiconnect.ErrModelNotFound},
+		{"missing note", func() error { return client.UpdateNoteFields(ctx, 1, map[string]string{"base_a": ""}) }, ankiconnect.ErrNoteNotFound},
+		{"missing note to get", func() error { _, err := client.GetNote(ctx, 1, nil); return err }, ankiconnect.ErrNoteNotFound},
+		{"missing media file", func() error { _, err := client.RetrieveMediaFile(ctx, "nope.mp3"); return err }, ankiconnect.ErrMediaNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.call(); !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	fake := ankifake.New()
+	server := fake.Start()
+	server.Close()
+
+	client := ankiconnect.NewClient(ankiconnect.Config{URL: server.URL})
+	if _, err := client.QueryNotes(context.Background(), "tag:audio"); !errors.Is(err, ankiconnect.ErrConnectionRefused) {
+		t.Errorf("QueryNotes() = %v, want ErrConnectionRefused", err)
+	}
+}
+
+func TestBatchWithAPIKey(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFake(t, "secret")
+
+	var noteIDs []int
+	for _, word := range []string{"Haus", "Hund", "Katze"} {
+		noteIDs = append(noteIDs, fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": word}}))
+	}
+
+	var batch ankiconnect.Batch
+	for _, noteID := range noteIDs {
+		batch.AddNoteTag(noteID, "audio-generated")
+	}
+	missing := batch.UpdateNoteFields(1, map[string]string{"base_a": ""})
+
+	results, err := client.SendBatch(ctx, &batch)
+	if err != nil {
+		t.Fatalf("SendBatch() = %v", err)
+	}
+	if len(results) != batch.Len() {
+		t.Fatalf("SendBatch() returned %d results for %d actions", len(results), batch.Len())
+	}
+	for index, result := range results {
+		if index == missing {
+			if !errors.Is(result.Err, ankiconnect.ErrNoteNotFound) {
+				t.Errorf("result of the missing note = %v, want ErrNoteNotFound", result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("result %d = %v", index, result.Err)
+		}
+	}
+	for _, noteID := range noteIDs {
+		if note, _ := fake.Note(noteID); !slices.Contains(note.Tags, "audio-generated") {
+			t.Errorf("note %d has the tags %v, want audio-generated", noteID, note.Tags)
+		}
+	}
+
+	// every action in the batch is rejected without the right key, not only the multi request
+	wrongKey := ankiconnect.NewClient(ankiconnect.Config{URL: client.URL(), APIKey: "wrong"})
+	if _, err := wrongKey.SendBatch(ctx, &batch); !errors.Is(err, ankiconnect.ErrPermissionDenied) {
+		t.Errorf("SendBatch() with the wrong key = %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestLargeBatch(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFake(t, "")
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+
+	// more actions than fit into one multi request, the results are still in order
+	var batch ankiconnect.Batch
+	for i := range 250 {
+		if i%50 == 49 {
+			batch.UpdateNoteFields(1, map[string]string{"base_a": ""})
+			continue
+		}
+		batch.AddNoteTag(noteID, "audio-generated")
+	}
+
+	results, err := client.SendBatch(ctx, &batch)
+	if err != nil || len(results) != 250 {
+		t.Fatalf("SendBatch() = %d results, %v, want 250", len(results), err)
+	}
+	for i, result := range results {
+		if failed := result.Err != nil; failed != (i%50 == 49) {
+			t.Errorf("result %d = %v", i, result.Err)
+		}
+	}
+}
+
+func TestMedia(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFake(t, "secret")
+
+	for _, filename := range []string{"1-base_a.mp3", "1-s1a.mp3", "2-base_a.mp3"} {
+		storedName, err := client.StoreMediaFile(ctx, filename, []byte(filename))
+		if err != nil || storedName != filename {
+			t.Fatalf("StoreMediaFile(%s) = %s, %v", filename, storedName, err)
+		}
+	}
+
+	data, err := client.RetrieveMediaFile(ctx, "1-s1a.mp3")
+	if err != nil || string(data) != "1-s1a.mp3" {
+		t.Errorf("RetrieveMediaFile() = %q, %v", data, err)
+	}
+
+	names, err := client.GetMediaFilesNames(ctx, "1-*.mp3")
+	if err != nil || !slices.Equal(names, []string{"1-base_a.mp3", "1-s1a.mp3"}) {
+		t.Errorf("GetMediaFilesNames() = %v, %v", names, err)
+	}
+
+	// deleted through a batch, which needs the key for every action
+	if err := client.DeleteMediaFiles(ctx, "1-base_a.mp3", "1-s1a.mp3"); err != nil {
+		t.Fatalf("DeleteMediaFiles() = %v", err)
+	}
+	if names := fake.MediaNames(); !slices.Equal(names, []string{"2-base_a.mp3"}) {
+		t.Errorf("media after DeleteMediaFiles() = %v", names)
+	}
+}
@@ -0,0 +1,83 @@
+package ankiconnect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// Sentinel errors for the failures callers usually want to handle, use with errors.Is
+var (
+	ErrDuplicateNote     = errors.New("duplicate note")
+	ErrNoteNotFound      = errors.New("note not found")
+	ErrModelNotFound     = errors.New("model not found")
+	ErrDeckNotFound      = errors.New("deck not found")
+	ErrFieldNotFound     = errors.New("field not found")
+	ErrMediaNotFound     = errors.New("media file not found")
+	ErrConnectionRefused = errors.New("anki connect refused the connection")
+	ErrPermissionDenied  = errors.New("permission denied")
+)
+
+// Error is an error message returned by AnkiConnect for a single action.
+// It unwraps to one of the sentinel errors when the message is recognized.
+type Error struct {
+	Action  string // the AnkiConnect action that failed, e.g. "addNote"
+	Message string // the error message as returned by AnkiConnect
+	kind    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("anki connect error: %s", e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+func newError(action, message string) *Error {
+	return &Error{
+		Action:  action,
+		Message: message,
+		kind:    classifyMessage(message),
+	}
+}
+
+// classifyMessage maps the messages AnkiConnect raises to sentinel errors
+func classifyMessage(message string) error {
+	lower := strings.ToLower(message)
+
+	switch {
+	case strings.Contains(lower, "duplicate"):
+		return ErrDuplicateNote
+	case strings.Contains(lower, "model was not found"), strings.Contains(lower, "model not found"):
+		return ErrModelNotFound
+	case strings.Contains(lower, "deck was not found"), strings.Contains(lower, "deck not found"):
+		return ErrDeckNotFound
+	case strings.Contains(lower, "note was not found"), strings.Contains(lower, "note not found"):
+		return ErrNoteNotFound
+	case strings.Contains(lower, "field") && (strings.Contains(lower, "not found") || strings.Contains(lower, "not exist")):
+		return ErrFieldNotFound
+	case strings.Contains(lower, "api key"), strings.Contains(lower, "permission"):
+		return ErrPermissionDenied
+	default:
+		return nil
+	}
+}
+
+// classifyRequestError marks transport errors that mean AnkiConnect isn't reachable
+func classifyRequestError(err error) error {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("request anki connect: %w: %w", ErrConnectionRefused, err)
+	}
+	return fmt.Errorf("request anki connect: %w", err)
+}
+
+// classifyStatus wraps non 200 responses. AnkiConnect answers 403 when the origin isn't allowed.
+func classifyStatus(statusCode int, body string) error {
+	if statusCode == http.StatusForbidden {
+		return fmt.Errorf("anki connect returned %d: %s: %w", statusCode, body, ErrPermissionDenied)
+	}
+	return fmt.Errorf("anki connect returned %d: %s", statusCode, body)
+}
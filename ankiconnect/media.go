@@ -0,0 +1,95 @@
+package ankiconnect
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// StoreMediaFile uploads data into anki's media folder, and returns the filename anki stored it as
+func (c *Client) StoreMediaFile(ctx context.Context, filename string, data []byte) (string, error) {
+	params := map[string]any{
+		"filename": filename,
+		"data":     base64.StdEncoding.EncodeToString(data),
+	}
+
+	responseBody, err := c.invoke(ctx, "storeMediaFile", params)
+	if err != nil {
+		return "", err
+	}
+
+	storedName := gjson.GetBytes(responseBody, "result").String()
+	if storedName == "" {
+		storedName = filename
+	}
+
+	return storedName, nil
+}
+
+// RetrieveMediaFile downloads a file from anki's media folder
+func (c *Client) RetrieveMediaFile(ctx context.Context, filename string) ([]byte, error) {
+	params := map[string]any{
+		"filename": filename,
+	}
+
+	responseBody, err := c.invoke(ctx, "retrieveMediaFile", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// the result is false when the file doesn't exist
+	result := gjson.GetBytes(responseBody, "result")
+	if result.Type != gjson.String {
+		return nil, fmt.Errorf("%w: %s", ErrMediaNotFound, filename)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(result.String())
+	if err != nil {
+		return nil, fmt.Errorf("decode media file %s: %w", filename, err)
+	}
+
+	return data, nil
+}
+
+// GetMediaFilesNames lists the files in anki's media folder that match the glob pattern, e.g. "123-*.mp3"
+func (c *Client) GetMediaFilesNames(ctx context.Context, pattern string) ([]string, error) {
+	params := map[string]any{
+		"pattern": pattern,
+	}
+
+	responseBody, err := c.invoke(ctx, "getMediaFilesNames", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, result := range gjson.GetBytes(responseBody, "result").Array() {
+		names = append(names, result.String())
+	}
+
+	return names, nil
+}
+
+// DeleteMediaFiles deletes the given files from anki's media folder
+func (c *Client) DeleteMediaFiles(ctx context.Context, filenames ...string) error {
+	// AnkiConnect only deletes one file per action, so send them together through multi
+	var batch Batch
+	for _, filename := range filenames {
+		batch.add("deleteMediaFile", map[string]any{"filename": filename})
+	}
+
+	results, err := c.SendBatch(ctx, &batch)
+	if err != nil {
+		return err
+	}
+
+	for index, result := range results {
+		if result.Err != nil {
+			return fmt.Errorf("delete media file %s: %w", filenames[index], result.Err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+// Package audiofake stands in for the tts backend and ffmpeg in tests, so that audio can be generated without
+// piper, espeak-ng or ffmpeg installed.
+package audiofake
+
+import (
+	"anki-voice/audio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Synthesizer makes up the audio of a text, see Audio. It fails for the texts in Fail.
+// It is safe for concurrent use.
+type Synthesizer struct {
+	Fail []string
+
+	mu    sync.Mutex
+	texts []string
+}
+
+func (s *Synthesizer) Synthesize(ctx context.Context, text string, options audio.VoiceOptions) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.texts = append(s.texts, text)
+
+	if slices.Contains(s.Fail, text) {
+		return nil, fmt.Errorf("fake synthesizer can't say %q", text)
+	}
+	return []byte(Audio(text, options)), nil
+}
+
+func (s *Synthesizer) Name() string {
+	return "fake"
+}
+
+// Texts returns the texts that were synthesized, sorted
+func (s *Synthesizer) Texts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	texts := slices.Clone(s.texts)
+	slices.Sort(texts)
+	return texts
+}
+
+// Audio is the content of the file that Synthesizer and the ffmpeg of InstallFFmpeg generate for a text,
+// so that tests can tell which text and voice a file was generated from
+func Audio(text string, options audio.VoiceOptions) string {
+	return fmt.Sprintf("%s|%s|%g", text, options.Speaker, options.LengthScale)
+}
+
+// InstallFFmpeg puts an ffmpeg on the PATH for the rest of the test, which copies its input to its output.
+// It skips the test on Windows, where the shell script can't run.
+func InstallFFmpeg(t testing.TB) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+
+	dir := t.TempDir()
+	// called as ffmpeg -y -i <input> ... <output>
+	script := "#!/bin/sh\nin=\"$3\"; for arg; do out=\"$arg\"; done; cp \"$in\" \"$out\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
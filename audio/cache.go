@@ -0,0 +1,284 @@
+package audio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultCacheMaxBytes = 1 << 30 // 1 GiB
+
+// outputFormat is part of the cache key, so changing the ffmpeg settings invalidates cached files
+const outputFormat = "mp3-libmp3lame-192k"
+
+// Cache stores generated audio on disk, keyed by a hash of everything that affects the result,
+// so unchanged phrases aren't synthesized again. Least recently used files are evicted once
+// the cache grows over MaxBytes.
+//
+// A nil *Cache is valid and caches nothing.
+type Cache struct {
+	Dir      string
+	MaxBytes int64 // 0 means DefaultCacheMaxBytes
+
+	mu    sync.Mutex
+	size  int64 // total size of the cached files, counted on the first put and then kept up to date
+	sized bool
+}
+
+type CacheStats struct {
+	Files  int
+	Bytes  int64
+	Oldest time.Time // least recently used entry
+	Newest time.Time // most recently used entry
+}
+
+// DefaultCacheDir returns the audio cache directory inside the user's cache directory
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "anki-voice", "audio"), nil
+}
+
+// Key returns the cache key for synthesizing text with the given synthesizer and options
+func (c *Cache) Key(synthesizer Synthesizer, text string, options VoiceOptions) string {
+	// json.Marshal sorts map keys, so equal inputs always result in the same key
+	input, _ := json.Marshal(map[string]any{
+		"text":    normalizeText(text),
+		"backend": synthesizer.Name(),
+		"voice":   options,
+		"format":  outputFormat,
+	})
+
+	sum := sha256.Sum256(input)
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateMP3 works like the package level GenerateMP3, but copies the audio from the cache when possible
+func (c *Cache) GenerateMP3(ctx context.Context, synthesizer Synthesizer, encoders *Encoders, text, outputPath string, options VoiceOptions) error {
+	if c == nil {
+		return GenerateMP3(ctx, synthesizer, encoders, text, outputPath, options)
+	}
+
+	key := c.Key(synthesizer, text, options)
+	hit, err := c.get(key, outputPath)
+	if err != nil {
+		return err
+	}
+	if hit {
+		return nil
+	}
+
+	if err := GenerateMP3(ctx, synthesizer, encoders, text, outputPath, options); err != nil {
+		return err
+	}
+
+	return c.put(key, outputPath)
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".mp3")
+}
+
+// get copies the cached file for key to outputPath, and reports whether there was one
+func (c *Cache) get(key, outputPath string) (bool, error) {
+	cachedPath := c.path(key)
+	if _, err := os.Stat(cachedPath); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	if err := copyFile(cachedPath, outputPath); err != nil {
+		return false, err
+	}
+
+	// the modification time is used as the last access time for eviction
+	now := time.Now()
+	if err := os.Chtimes(cachedPath, now, now); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Cache) put(key, sourcePath string) error {
+	cachedPath := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(cachedPath), 0o755); err != nil {
+		return err
+	}
+
+	// copy to a unique temporary name first, so a concurrent reader never sees a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(cachedPath), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := copyFile(sourcePath, tmp.Name()); err != nil {
+		return err
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.sized {
+		// count the files once, instead of walking the whole cache on every put
+		if _, _, err := c.prune(-1); err != nil {
+			return err
+		}
+	}
+	if replaced, err := os.Stat(cachedPath); err == nil {
+		c.size -= replaced.Size()
+	}
+	if err := os.Rename(tmp.Name(), cachedPath); err != nil {
+		return err
+	}
+	c.size += info.Size()
+
+	maxBytes := c.Limit()
+	if c.size <= maxBytes {
+		return nil
+	}
+	_, _, err = c.prune(maxBytes)
+	return err
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) entries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == c.Dir {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".mp3") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// least recently used first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	return entries, nil
+}
+
+// Limit returns the size the cache is kept under, MaxBytes or DefaultCacheMaxBytes
+func (c *Cache) Limit() int64 {
+	if c.MaxBytes == 0 {
+		return DefaultCacheMaxBytes
+	}
+	return c.MaxBytes
+}
+
+// Stats returns the number and total size of cached files
+func (c *Cache) Stats() (CacheStats, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return CacheStats{}, err
+	}
+
+	var stats CacheStats
+	for _, entry := range entries {
+		stats.Files++
+		stats.Bytes += entry.size
+	}
+	if len(entries) > 0 {
+		stats.Oldest = entries[0].modTime
+		stats.Newest = entries[len(entries)-1].modTime
+	}
+
+	return stats, nil
+}
+
+// Prune evicts the least recently used files until the cache is at most maxBytes large,
+// and returns the number of removed files and bytes. Prune(0) empties the cache.
+func (c *Cache) Prune(maxBytes int64) (int, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prune(maxBytes)
+}
+
+// prune works like Prune, and counts the size of the cache. A negative maxBytes only counts it. c.mu must be held.
+func (c *Cache) prune(maxBytes int64) (int, int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+
+	c.size, c.sized = total, true
+
+	removed := 0
+	var freed int64
+	for _, entry := range entries {
+		if maxBytes < 0 || total <= maxBytes {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, freed, err
+		}
+		total -= entry.size
+		c.size = total
+		freed += entry.size
+		removed++
+	}
+
+	return removed, freed, nil
+}
+
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,50 @@
+package audio
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachePutEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir(), MaxBytes: 250}
+	source := filepath.Join(t.TempDir(), "audio.mp3")
+	if err := os.WriteFile(source, []byte(strings.Repeat("x", 100)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"aa01", "bb02", "cc03"}
+	for i, key := range keys {
+		if err := cache.put(key, source); err != nil {
+			t.Fatalf("put(%s): %v", key, err)
+		}
+		// the modification time is the last access, so make the order unambiguous
+		modTime := time.Now().Add(time.Duration(i-len(keys)) * time.Minute)
+		if err := os.Chtimes(cache.path(key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(cache.path("aa01")); !os.IsNotExist(err) {
+		t.Errorf("the least recently used file is still cached: %v", err)
+	}
+	for _, key := range keys[1:] {
+		if _, err := os.Stat(cache.path(key)); err != nil {
+			t.Errorf("%s was evicted: %v", key, err)
+		}
+	}
+
+	// putting the same key again replaces the file, without counting it twice
+	if err := cache.put("cc03", source); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || stats.Bytes != 200 || cache.size != 200 {
+		t.Errorf("cache has %d files, %d bytes, counted %d bytes, want 2 files and 200 bytes", stats.Files, stats.Bytes, cache.size)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package audio
+
+import (
+	"context"
+	"os/exec"
+)
+
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
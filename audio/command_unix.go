@@ -0,0 +1,17 @@
+//go:build unix
+
+package audio
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// command returns an exec.Cmd that runs in its own process group, so that the SIGINT of Ctrl-C in the terminal
+// only reaches this process, which finishes the current note before it stops
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
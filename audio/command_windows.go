@@ -0,0 +1,17 @@
+//go:build windows
+
+package audio
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// command returns an exec.Cmd that runs in its own process group, so that Ctrl-C in the console
+// only reaches this process, which finishes the current note before it stops
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	return cmd
+}
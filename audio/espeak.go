@@ -0,0 +1,51 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Espeak synthesizes with the espeak-ng binary. Robotic, but available almost everywhere.
+type Espeak struct {
+	Binary string // defaults to "espeak-ng" on the PATH
+	Voice  string // e.g. "de", used when VoiceOptions.Voice is empty
+}
+
+func (e *Espeak) Synthesize(ctx context.Context, text string, options VoiceOptions) ([]byte, error) {
+	binary := e.Binary
+	if binary == "" {
+		binary = "espeak-ng"
+	}
+
+	args := []string{"--stdout", "--stdin"}
+	voice := options.Voice
+	if voice == "" {
+		voice = e.Voice
+	}
+	if voice != "" {
+		args = append(args, "-v", voice)
+	}
+	if options.LengthScale != 0 {
+		// espeak-ng has no length scale, so scale its default of 175 words per minute instead
+		args = append(args, "-s", strconv.Itoa(int(175/options.LengthScale)))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := command(ctx, binary, args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("espeak-ng failed: %v\nDetails:\n%s", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (e *Espeak) Name() string {
+	return fmt.Sprintf("%s %s", BackendEspeak, e.Voice)
+}
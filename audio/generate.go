@@ -2,28 +2,28 @@ package audio
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 )
 
-func GenerateMP3(text, outputPath string) error {
+// GenerateMP3 synthesizes text into an MP3 file at outputPath. encoders limits the ffmpeg processes
+// of concurrent calls, it may be nil.
+func GenerateMP3(ctx context.Context, synthesizer Synthesizer, encoders *Encoders, text, outputPath string, options VoiceOptions) error {
 	wavPath := fmt.Sprintf("%s.wav", outputPath)
 
-	// ignore non breaking spaces
-	trimmed := strings.ReplaceAll(text, "&nbsp;", "")
-	trimmed = strings.TrimSpace(trimmed)
-
-	err := generateWav(trimmed, wavPath)
+	wav, err := synthesizer.Synthesize(ctx, normalizeText(text), options)
 	if err != nil {
 		return err
 	}
+
+	if err := os.WriteFile(wavPath, wav, 0o644); err != nil {
+		return err
+	}
 	defer os.Remove(wavPath)
 
-	err = convertWavToMp3(wavPath, outputPath)
+	err = convertWavToMp3(ctx, encoders, wavPath, outputPath)
 	if err != nil {
 		return err
 	}
@@ -31,52 +31,26 @@ func GenerateMP3(text, outputPath string) error {
 	return nil
 }
 
-func generateWav(text, outputPath string) error {
-	// TODO: try to adjust speed with length_scale? currently sending this just causes the voice
-	// to read through the whole payload
-	// payload := map[string]any{
-	// 	"text":  text,
-	// 	"length_scale": 1.2,
-	// }
-
-	// body, err := json.Marshal(payload)
-	// if err != nil {
-	// 	return fmt.Errorf("marshal request failed: %w", err)
-	// }
-
-	// response, err := http.Post("http://localhost:9999", "application/json", bytes.NewReader(body))
-	// if err != nil {
-	// 	return err
-	// }
-	// defer response.Body.Close()
-
-	response, err := http.Post("http://localhost:9999", "application/json", strings.NewReader(text))
+func convertWavToMp3(ctx context.Context, encoders *Encoders, input, output string) error {
+	release, err := encoders.acquire(ctx)
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
+	defer release()
 
-	out, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, response.Body); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func convertWavToMp3(input, output string) error {
 	var stderr bytes.Buffer
-	cmd := exec.Command("ffmpeg", "-y", "-i", input, "-codec:a", "libmp3lame", "-b:a", "192k", output)
+	cmd := command(ctx, "ffmpeg", "-y", "-i", input, "-codec:a", "libmp3lame", "-b:a", "192k", output)
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return fmt.Errorf("ffmpeg failed: %v\nDetails:\n%s", err, stderr.String())
 	}
 	return nil
 }
+
+func normalizeText(text string) string {
+	// ignore non breaking spaces
+	trimmed := strings.ReplaceAll(text, "&nbsp;", "")
+	return strings.TrimSpace(trimmed)
+}
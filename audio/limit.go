@@ -0,0 +1,60 @@
+package audio
+
+import (
+	"context"
+)
+
+// DefaultMaxSyntheses is the default number of texts synthesized at the same time, see LimitSynthesizer
+const DefaultMaxSyntheses = 2
+
+// Encoders limits how many ffmpeg processes run at the same time. A nil *Encoders doesn't limit them.
+type Encoders struct {
+	slots chan struct{}
+}
+
+// NewEncoders returns a limit of n ffmpeg processes at the same time, to share between concurrent GenerateMP3 calls
+func NewEncoders(n int) *Encoders {
+	return &Encoders{slots: make(chan struct{}, max(n, 1))}
+}
+
+// acquire takes a slot, and returns the function that gives it back
+func (e *Encoders) acquire(ctx context.Context) (func(), error) {
+	if e == nil {
+		return func() {}, nil
+	}
+	if err := acquire(ctx, e.slots); err != nil {
+		return nil, err
+	}
+	return func() { <-e.slots }, nil
+}
+
+// acquire takes a slot of slots, or returns the context's error when it's canceled while waiting
+func acquire(ctx context.Context, slots chan struct{}) error {
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedSynthesizer runs at most cap(slots) syntheses of the wrapped synthesizer at the same time
+type limitedSynthesizer struct {
+	Synthesizer
+	slots chan struct{}
+}
+
+// LimitSynthesizer wraps synthesizer so that at most n texts are synthesized at the same time.
+// The cache key of the wrapped synthesizer doesn't change.
+func LimitSynthesizer(synthesizer Synthesizer, n int) Synthesizer {
+	return &limitedSynthesizer{Synthesizer: synthesizer, slots: make(chan struct{}, max(n, 1))}
+}
+
+func (s *limitedSynthesizer) Synthesize(ctx context.Context, text string, options VoiceOptions) ([]byte, error) {
+	if err := acquire(ctx, s.slots); err != nil {
+		return nil, err
+	}
+	defer func() { <-s.slots }()
+
+	return s.Synthesizer.Synthesize(ctx, text, options)
+}
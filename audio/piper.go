@@ -0,0 +1,177 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultPiperURL = "http://localhost:9999"
+
+const (
+	// PiperAPIJSON is the request format of piper's own http_server, which accepts synthesis parameters
+	PiperAPIJSON = "json"
+	// PiperAPIText sends the text as the raw request body, for older servers that only accept text
+	PiperAPIText = "text"
+)
+
+// PiperHTTP synthesizes with a piper HTTP server, e.g. the one in docker-compose.yml
+type PiperHTTP struct {
+	URL    string // defaults to DefaultPiperURL
+	API    string // PiperAPIJSON (default) or PiperAPIText
+	Voice  string // voice loaded by the server, used when VoiceOptions.Voice is empty
+	Client *http.Client
+}
+
+func (p *PiperHTTP) Synthesize(ctx context.Context, text string, options VoiceOptions) ([]byte, error) {
+	url := p.url()
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var body []byte
+	if p.API == PiperAPIText {
+		// older servers read the whole body as text, so parameters would be read out loud
+		body = []byte(text)
+	} else {
+		var err error
+		body, err = json.Marshal(p.payload(text, options))
+		if err != nil {
+			return nil, fmt.Errorf("marshal request failed: %w", err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("piper returned %d: %s", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	return responseBody, nil
+}
+
+func (p *PiperHTTP) Name() string {
+	return fmt.Sprintf("%s %s %s", BackendPiperHTTP, p.url(), p.Voice)
+}
+
+func (p *PiperHTTP) url() string {
+	if p.URL == "" {
+		return DefaultPiperURL
+	}
+	return p.URL
+}
+
+// payload builds the JSON request described in piper's API_HTTP.md, leaving out unset options
+func (p *PiperHTTP) payload(text string, options VoiceOptions) map[string]any {
+	payload := map[string]any{
+		"text": text,
+	}
+
+	voice := options.Voice
+	if voice == "" {
+		voice = p.Voice
+	}
+	if voice != "" {
+		payload["voice"] = voice
+	}
+
+	if options.Speaker != "" {
+		if speakerID, err := strconv.Atoi(options.Speaker); err == nil {
+			payload["speaker_id"] = speakerID
+		} else {
+			payload["speaker"] = options.Speaker
+		}
+	}
+	if options.LengthScale != 0 {
+		payload["length_scale"] = options.LengthScale
+	}
+	if options.NoiseScale != 0 {
+		payload["noise_scale"] = options.NoiseScale
+	}
+	if options.NoiseWScale != 0 {
+		payload["noise_w_scale"] = options.NoiseWScale
+	}
+
+	return payload
+}
+
+// PiperCLI synthesizes by running the local piper binary
+type PiperCLI struct {
+	Binary string // defaults to "piper" on the PATH
+	Model  string // path of the .onnx voice model, used when VoiceOptions.Voice is empty
+}
+
+func (p *PiperCLI) Synthesize(ctx context.Context, text string, options VoiceOptions) ([]byte, error) {
+	binary := p.Binary
+	if binary == "" {
+		binary = "piper"
+	}
+	model := options.Voice
+	if model == "" {
+		model = p.Model
+	}
+
+	// piper can't write WAV to stdout, so go through a temporary file
+	dir, err := os.MkdirTemp("", "piper-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	wavPath := filepath.Join(dir, "out.wav")
+
+	args := []string{"--model", model, "--output_file", wavPath}
+	if options.Speaker != "" {
+		args = append(args, "--speaker", options.Speaker)
+	}
+	if options.LengthScale != 0 {
+		args = append(args, "--length_scale", formatFloat(options.LengthScale))
+	}
+	if options.NoiseScale != 0 {
+		args = append(args, "--noise_scale", formatFloat(options.NoiseScale))
+	}
+	if options.NoiseWScale != 0 {
+		args = append(args, "--noise_w", formatFloat(options.NoiseWScale))
+	}
+
+	var stderr bytes.Buffer
+	cmd := command(ctx, binary, args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("piper failed: %v\nDetails:\n%s", err, stderr.String())
+	}
+
+	return os.ReadFile(wavPath)
+}
+
+func (p *PiperCLI) Name() string {
+	return fmt.Sprintf("%s %s", BackendPiperCLI, p.Model)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,57 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	BackendPiperHTTP = "piper-http"
+	BackendPiperCLI  = "piper"
+	BackendEspeak    = "espeak-ng"
+)
+
+// VoiceOptions select how a text is spoken. Zero values use the backend's defaults.
+type VoiceOptions struct {
+	Voice       string  // piper model or espeak-ng voice name, depending on the backend
+	Speaker     string  // speaker name or numeric id on multi-speaker piper models
+	LengthScale float64 // > 1 speaks slower, < 1 faster
+	NoiseScale  float64 // piper generator noise
+	NoiseWScale float64 // piper phoneme width noise
+}
+
+// Synthesizer turns text into speech, returned as a WAV file
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string, options VoiceOptions) ([]byte, error)
+	// Name identifies the backend and its default voice, it is part of the audio cache key
+	Name() string
+}
+
+// Config selects and configures a Synthesizer. Empty fields use the backend's defaults.
+type Config struct {
+	Backend string // one of BackendPiperHTTP, BackendPiperCLI or BackendEspeak, defaults to BackendPiperHTTP
+	URL     string // piper HTTP server URL
+	API     string // piper HTTP request format, PiperAPIJSON (default) or PiperAPIText
+	Binary  string // path of the piper or espeak-ng binary
+	Voice   string // default voice, see VoiceOptions.Voice
+}
+
+// New returns the Synthesizer for config.Backend
+func New(config Config) (Synthesizer, error) {
+	switch config.Backend {
+	case "", BackendPiperHTTP:
+		if config.API != "" && config.API != PiperAPIJSON && config.API != PiperAPIText {
+			return nil, fmt.Errorf("unknown piper api %q, expected %s or %s", config.API, PiperAPIJSON, PiperAPIText)
+		}
+		return &PiperHTTP{URL: config.URL, API: config.API, Voice: config.Voice}, nil
+	case BackendPiperCLI:
+		if config.Voice == "" {
+			return nil, fmt.Errorf("the %s backend needs a voice model", BackendPiperCLI)
+		}
+		return &PiperCLI{Binary: config.Binary, Model: config.Voice}, nil
+	case BackendEspeak:
+		return &Espeak{Binary: config.Binary, Voice: config.Voice}, nil
+	default:
+		return nil, fmt.Errorf("unknown tts backend %q, expected one of %s, %s, %s", config.Backend, BackendPiperHTTP, BackendPiperCLI, BackendEspeak)
+	}
+}
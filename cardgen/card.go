@@ -0,0 +1,216 @@
+package cardgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	ProviderGemini = "gemini"
+	ProviderOpenAI = "openai"
+	ProviderFake   = "fake"
+)
+
+// CardGenerator turns a word into the fields of a note
+type CardGenerator interface {
+	Generate(ctx context.Context, request Request) (Card, error)
+	// Name identifies the provider and model in logs
+	Name() string
+}
+
+// Config selects and configures a CardGenerator. Empty fields use the provider's defaults.
+type Config struct {
+	Provider   string // one of ProviderGemini, ProviderOpenAI or ProviderFake, defaults to ProviderGemini
+	Model      string
+	URL        string // base URL of the OpenAI-compatible API
+	APIKey     string
+	FixtureDir string // fixtures of the fake provider
+}
+
+// New returns the CardGenerator for config.Provider
+func New(ctx context.Context, config Config) (CardGenerator, error) {
+	switch config.Provider {
+	case "", ProviderGemini:
+		return NewGemini(ctx, config.APIKey, config.Model)
+	case ProviderOpenAI:
+		return &OpenAI{URL: config.URL, APIKey: config.APIKey, Model: config.Model}, nil
+	case ProviderFake:
+		return &Fake{Dir: config.FixtureDir}, nil
+	default:
+		return nil, fmt.Errorf("unknown card provider %q, expected one of %s, %s, %s", config.Provider, ProviderGemini, ProviderOpenAI, ProviderFake)
+	}
+}
+
+// Request asks for the card of a word. Previous and Problems ask the model to correct a card that failed Validate.
+type Request struct {
+	Word     string
+	Previous *Card
+	Problems []string
+}
+
+// prompt returns the text sent to the model
+func (r Request) prompt() string {
+	text := fmt.Sprintf(prompt, r.Word)
+	if r.Previous == nil {
+		return text
+	}
+
+	previous, err := json.Marshal(r.Previous)
+	if err != nil {
+		// a Card only has strings
+		panic(err)
+	}
+	return fmt.Sprintf(revisePrompt, text, previous, "* "+strings.Join(r.Problems, "\n* "))
+}
+
+// prompt asks for a card for the word in %s, as JSON
+const prompt = `
+Return the following fields in a JSON structure for the word: %s
+The values will be used for creating Anki cards to learn German vocabulary.
+
+* base_d: the base form the German word.
+  * When a noun, omit the article. e.g. "Abgas".
+	* When a reflexive verb, should start with "sich".
+* full_d: German word. 
+  * When a verb, should be a comma separated list of infinitive, present, simple past, and present perfect. e.g. "analysieren, analysiert, analysierte, hat analysiert"
+	* When a reflexive verb, should start with "sich". e.g. "sich amüsieren, amüsiert sich, amüsierte sich, hat sich amüsiert"
+  * When a noun, should include the article, and the ending in plural. e.g. "das Abgas, -e", "das Alter, -". This is just a combination of the fields artikel_d, base_d, and plural_d.
+* base_e: the English translation. e.g. "to analyze"
+  * If an English translation is provided in the prompt, make sure base_e covers what is provided
+* artikel_d:
+  * When a noun, the article. 
+  * When not a noun, blank string
+* plural_d: 
+  * When a noun, the plural ending. "-" if the ending does not change, and e.g. "-e" if an "e" is added.
+  * When not a noun, blank string
+* s1: The first example sentence in German. Create a typical sentence that the word would be used in.
+* s1e: The English translation of s1.
+* s2: The second example sentence in German. If there is more than one meaning of the word, then create a sentence that demonstrates a use of the second meaning.
+* s2e: The English translation of s2.
+* s3: The third example sentence in German. Only include If there are more than two commonly used meanings of the word. Otherwise, leave blank.
+* s3e: The English translation of s3.
+* s4: The fourth example sentence in German. Only include If there are more than three commonly used meanings of the word. Otherwise, leave blank.
+* s4e: The English translation of s4.
+
+Other things to note: 
+* If the word is in plural, convert it to singular
+* Return ONLY the JSON object, and do not include any other content or text.
+`
+
+// revisePrompt asks to correct a card: the original prompt, the previous answer and its problems
+const revisePrompt = `%s
+Your previous answer was:
+%s
+
+It has these problems:
+%s
+
+Return the corrected JSON object.
+`
+
+// Card is the content of a generated note
+type Card struct {
+	FullDeutsch    string `json:"full_d"`
+	BaseDeutsch    string `json:"base_d"`
+	BaseEnglish    string `json:"base_e"`
+	ArticleDeutsch string `json:"artikel_d"`
+	PluralDeutsch  string `json:"plural_d"`
+	S1             string `json:"s1"`
+	S1e            string `json:"s1e"`
+	S2             string `json:"s2"`
+	S2e            string `json:"s2e"`
+	S3             string `json:"s3"`
+	S3e            string `json:"s3e"`
+	S4             string `json:"s4"`
+	S4e            string `json:"s4e"`
+}
+
+// Fields returns the note fields of the card, key: field name
+func (card Card) Fields() map[string]string {
+	return map[string]string{
+		"full_d":    card.FullDeutsch,
+		"base_d":    card.BaseDeutsch,
+		"base_e":    card.BaseEnglish,
+		"artikel_d": card.ArticleDeutsch,
+		"plural_d":  card.PluralDeutsch,
+		"s1":        card.S1,
+		"s1e":       card.S1e,
+		"s2":        card.S2,
+		"s2e":       card.S2e,
+		"s3":        card.S3,
+		"s3e":       card.S3e,
+		"s4":        card.S4,
+		"s4e":       card.S4e,
+	}
+}
+
+// codeBlockRegex matches a markdown code block, which models like to wrap JSON in
+var codeBlockRegex = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*(.*?)```")
+
+// ParseError is returned when a model's response doesn't contain a card. It includes the raw response.
+type ParseError struct {
+	Response string
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("could not read the card from the response: %v\nresponse:\n%s", e.Err, e.Response)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseCard reads a card from a model's response. Models with structured output return just the JSON, others
+// may wrap it in a code block or add explanations around it, see extractJSON.
+func parseCard(response string) (Card, error) {
+	jsonText := extractJSON(response)
+	if jsonText == "" {
+		return Card{}, &ParseError{Response: response, Err: errors.New("empty response")}
+	}
+
+	var card Card
+	if err := json.Unmarshal([]byte(jsonText), &card); err != nil {
+		return Card{}, &ParseError{Response: response, Err: err}
+	}
+	return card, nil
+}
+
+// extractJSON returns the JSON object in a response: the whole response when it is valid JSON,
+// otherwise the content of the first code block, or everything from the first "{" to the last "}"
+func extractJSON(response string) string {
+	text := strings.TrimSpace(response)
+	if json.Valid([]byte(text)) {
+		return text
+	}
+
+	if match := codeBlockRegex.FindStringSubmatch(text); match != nil {
+		block := strings.TrimSpace(match[1])
+		if json.Valid([]byte(block)) {
+			return block
+		}
+	}
+
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start >= 0 && end > start {
+		return text[start : end+1]
+	}
+	return text
+}
+
+// FieldNames returns the JSON names of the fields of Card, which are also the names of the note fields, in their order
+func FieldNames() []string {
+	cardType := reflect.TypeFor[Card]()
+	fields := make([]string, 0, cardType.NumField())
+	for i := range cardType.NumField() {
+		name, _, _ := strings.Cut(cardType.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
@@ -0,0 +1,88 @@
+package cardgen
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name, response, want string
+	}{
+		{"only json", `{"base_d": "Haus"}`, `{"base_d": "Haus"}`},
+		{"surrounding space", "\n  {\"base_d\": \"Haus\"}\n", `{"base_d": "Haus"}`},
+		{"code block", "```json\n{\"base_d\": \"Haus\"}\n```", `{"base_d": "Haus"}`},
+		{"code block without language", "```\n{\"base_d\": \"Haus\"}\n```", `{"base_d": "Haus"}`},
+		{"text around a code block", "Here is the card:\n```json\n{\"base_d\": \"Haus\"}\n```\nHope it helps!", `{"base_d": "Haus"}`},
+		{"text around json", `Sure! {"base_d": "Haus"} Let me know.`, `{"base_d": "Haus"}`},
+		{"braces in the values", `Card: {"s1": "Ein {Haus}"} done`, `{"s1": "Ein {Haus}"}`},
+		{"invalid code block, json after it", "```\nnot json\n```\n{\"base_d\": \"Haus\"}", `{"base_d": "Haus"}`},
+		{"no json", "I can't help with that.", "I can't help with that."},
+		{"empty", "  ", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := extractJSON(test.response); got != test.want {
+				t.Errorf("extractJSON(%q) = %q, want %q", test.response, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCard(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     Card
+		// wantErr is a substring of the error, empty when the card is read
+		wantErr string
+	}{
+		{
+			name:     "json",
+			response: `{"base_d": "Haus", "artikel_d": "das", "s1": "Das Haus ist alt."}`,
+			want:     Card{BaseDeutsch: "Haus", ArticleDeutsch: "das", S1: "Das Haus ist alt."},
+		},
+		{
+			name:     "code block",
+			response: "```json\n{\"base_d\": \"Haus\"}\n```",
+			want:     Card{BaseDeutsch: "Haus"},
+		},
+		{
+			name:     "unknown fields are ignored",
+			response: `{"base_d": "Haus", "notes": "a building"}`,
+			want:     Card{BaseDeutsch: "Haus"},
+		},
+		{name: "empty", response: "", wantErr: "empty response"},
+		{name: "no json", response: "I can't help with that.", wantErr: "I can't help with that."},
+		{name: "cut off json", response: `{"base_d": "Haus", "s1": "Das`, wantErr: `"s1": "Das`},
+		{name: "wrong type", response: `{"base_d": 1}`, wantErr: "cannot unmarshal number"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			card, err := parseCard(test.response)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseCard() = %v", err)
+				}
+				if card != test.want {
+					t.Errorf("parseCard() = %+v, want %+v", card, test.want)
+				}
+				return
+			}
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("parseCard() = %v, want a *ParseError", err)
+			}
+			if parseErr.Response != test.response {
+				t.Errorf("ParseError.Response = %q, want the whole response %q", parseErr.Response, test.response)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("parseCard() = %v, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package cardgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Fake returns the same card for a word every time, without calling a model, and ignores requests for corrections. It reads <word>.json from Dir
+// when there is such a fixture, and otherwise makes up a card from the word itself.
+// Together with ankifake and espeak-ng, it runs generate-card without any external service.
+type Fake struct {
+	Dir string
+}
+
+// FakeTag marks the notes of cards made by Fake, so that placeholder cards that were added to a real collection
+// are easy to find and delete
+const FakeTag = "anki-voice-fake"
+
+func (f *Fake) Generate(ctx context.Context, request Request) (Card, error) {
+	word := request.Word
+	if f.Dir != "" {
+		data, err := os.ReadFile(filepath.Join(f.Dir, word+".json"))
+		if err == nil {
+			card, err := parseCard(string(data))
+			if err != nil {
+				return Card{}, fmt.Errorf("fixture for %s: %w", word, err)
+			}
+			return card, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return Card{}, err
+		}
+	}
+
+	return Card{
+		FullDeutsch: word,
+		BaseDeutsch: word,
+		BaseEnglish: "(" + word + ")",
+		S1:          fmt.Sprintf("Das Wort ist %s.", word),
+		S1e:         fmt.Sprintf("The word is %s.", word),
+		S2:          fmt.Sprintf("Ich kenne %s.", word),
+		S2e:         fmt.Sprintf("I know %s.", word),
+	}, nil
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
@@ -0,0 +1,54 @@
+package cardgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFakeMakesValidCards(t *testing.T) {
+	fake := &Fake{}
+	for _, word := range []string{"Haus", "benehmen", "Straßenbahn"} {
+		card, err := GenerateValid(context.Background(), fake, word, 0)
+		if err != nil {
+			t.Errorf("GenerateValid(%s) = %v", word, err)
+		}
+		if card.BaseDeutsch != word {
+			t.Errorf("GenerateValid(%s) = %+v, want the word in base_d", word, card)
+		}
+	}
+}
+
+func TestFakeReadsFixtures(t *testing.T) {
+	dir := t.TempDir()
+	data, err := json.Marshal(validNoun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Haus.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kaputt.json"), []byte("not a card"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fake := &Fake{Dir: dir}
+
+	card, err := fake.Generate(context.Background(), Request{Word: "Haus"})
+	if err != nil || card != validNoun {
+		t.Errorf("Generate(Haus) = %+v, %v, want the fixture %+v", card, err, validNoun)
+	}
+
+	// without a fixture, the card is made up from the word
+	card, err = fake.Generate(context.Background(), Request{Word: "Hund"})
+	if err != nil || card.BaseDeutsch != "Hund" {
+		t.Errorf("Generate(Hund) = %+v, %v, want a card of Hund", card, err)
+	}
+
+	var parseErr *ParseError
+	if _, err := fake.Generate(context.Background(), Request{Word: "kaputt"}); !errors.As(err, &parseErr) {
+		t.Errorf("Generate(kaputt) = %v, want a *ParseError for the broken fixture", err)
+	}
+}
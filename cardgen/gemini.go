@@ -0,0 +1,76 @@
+package cardgen
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/genai"
+)
+
+const DefaultGeminiModel = "gemini-2.5-flash"
+
+// Gemini generates cards with Google's Gemini API
+type Gemini struct {
+	Client *genai.Client
+	Model  string // defaults to DefaultGeminiModel
+}
+
+func NewGemini(ctx context.Context, apiKey, model string) (*Gemini, error) {
+	if apiKey == "" {
+		return nil, errors.New("GEMINI_API_KEY is not set")
+	}
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey})
+	if err != nil {
+		return nil, err
+	}
+	return &Gemini{Client: client, Model: model}, nil
+}
+
+func (g *Gemini) Generate(ctx context.Context, request Request) (Card, error) {
+	result, err := g.Client.Models.GenerateContent(
+		ctx,
+		g.model(),
+		genai.Text(request.prompt()),
+		&genai.GenerateContentConfig{
+			ResponseMIMEType: "application/json",
+			ResponseSchema:   geminiSchema(),
+		},
+	)
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) && retryable(apiErr.Code) {
+		return Card{}, &RetryableError{StatusCode: apiErr.Code, RetryAfter: geminiRetryDelay(apiErr.Details), Err: err}
+	}
+	if err != nil {
+		return Card{}, err
+	}
+	log.Printf("Gemini response: \n%s\n", result.Text())
+
+	return parseCard(result.Text())
+}
+
+func (g *Gemini) Name() string {
+	return "gemini " + g.model()
+}
+
+func (g *Gemini) model() string {
+	if g.Model == "" {
+		return DefaultGeminiModel
+	}
+	return g.Model
+}
+
+// geminiSchema is the response schema of a Card, so that Gemini returns the card as plain JSON
+func geminiSchema() *genai.Schema {
+	schema := &genai.Schema{
+		Type:       genai.TypeObject,
+		Properties: map[string]*genai.Schema{},
+	}
+	for _, field := range FieldNames() {
+		schema.Properties[field] = &genai.Schema{Type: genai.TypeString}
+		schema.PropertyOrdering = append(schema.PropertyOrdering, field)
+		schema.Required = append(schema.Required, field)
+	}
+	return schema
+}
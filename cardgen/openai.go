@@ -0,0 +1,154 @@
+package cardgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	DefaultOpenAIURL   = "https://api.openai.com/v1"
+	DefaultOpenAIModel = "gpt-4o-mini"
+)
+
+// OpenAI generates cards with an OpenAI-compatible chat completions API,
+// e.g. OpenAI itself, or a local llama.cpp or Ollama server
+type OpenAI struct {
+	URL    string // base URL, e.g. "http://localhost:11434/v1" for Ollama. defaults to DefaultOpenAIURL
+	APIKey string // optional for local servers
+	Model  string // defaults to DefaultOpenAIModel
+	Client *http.Client
+
+	// unstructured is set once the server rejected structured output, so that it isn't asked for again
+	unstructured atomic.Bool
+}
+
+// errResponseFormat is returned by complete when the server rejects the response_format of structured output
+var errResponseFormat = errors.New("structured output not supported")
+
+func (o *OpenAI) Generate(ctx context.Context, request Request) (Card, error) {
+	structured := !o.unstructured.Load()
+	content, err := o.complete(ctx, request, structured)
+	if structured && errors.Is(err, errResponseFormat) {
+		// e.g. older llama.cpp and vLLM versions. parseCard finds the JSON in the answer anyway
+		log.Printf("%v, asking again without a schema", err)
+		o.unstructured.Store(true)
+		content, err = o.complete(ctx, request, false)
+	}
+	if err != nil {
+		return Card{}, err
+	}
+	log.Printf("%s response: \n%s\n", o.model(), content)
+
+	return parseCard(content)
+}
+
+// complete sends the prompt of request to the chat completions API and returns the content of the answer.
+// With structured, the answer is asked for as JSON that matches the schema of a Card.
+func (o *OpenAI) complete(ctx context.Context, request Request, structured bool) (string, error) {
+	payload := map[string]any{
+		"model": o.model(),
+		"messages": []map[string]string{
+			{"role": "user", "content": request.prompt()},
+		},
+	}
+	if structured {
+		// most servers without structured output ignore it, the others are asked again without it
+		payload["response_format"] = map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "card",
+				"strict": true,
+				"schema": jsonSchema(),
+			},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url()+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+
+	client := o.Client
+	if client == nil {
+		// local models can take a while for a whole card
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return "", fmt.Errorf("request %s: %w", o.url(), err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%s returned %s: %s", o.url(), response.Status, strings.TrimSpace(string(responseBody)))
+		switch {
+		case retryable(response.StatusCode):
+			return "", &RetryableError{StatusCode: response.StatusCode, RetryAfter: retryAfter(response.Header.Get("Retry-After")), Err: err}
+		case structured && response.StatusCode >= 400 && response.StatusCode < 500 && bytes.Contains(responseBody, []byte("response_format")):
+			return "", fmt.Errorf("%w: %w", errResponseFormat, err)
+		}
+		return "", err
+	}
+
+	content := gjson.GetBytes(responseBody, "choices.0.message.content")
+	if !content.Exists() {
+		return "", fmt.Errorf("%s returned no message: %s", o.url(), responseBody)
+	}
+	return content.String(), nil
+}
+
+func (o *OpenAI) Name() string {
+	return "openai " + o.model()
+}
+
+func (o *OpenAI) url() string {
+	if o.URL == "" {
+		return DefaultOpenAIURL
+	}
+	return strings.TrimSuffix(o.URL, "/")
+}
+
+func (o *OpenAI) model() string {
+	if o.Model == "" {
+		return DefaultOpenAIModel
+	}
+	return o.Model
+}
+
+// jsonSchema is the JSON schema of a Card
+func jsonSchema() map[string]any {
+	fields := FieldNames()
+	properties := make(map[string]any, len(fields))
+	for _, field := range fields {
+		properties[field] = map[string]string{"type": "string"}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             fields,
+		"additionalProperties": false,
+	}
+}
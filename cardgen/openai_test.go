@@ -0,0 +1,85 @@
+package cardgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeChatServer answers chat completions with card. With rejectSchema it fails requests that ask for structured
+// output like servers that don't support it. It records whether each request asked for it.
+func fakeChatServer(t *testing.T, card string, status int, rejectSchema bool) (*httptest.Server, *[]bool) {
+	t.Helper()
+	var structured []bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		_, ok := body["response_format"]
+		structured = append(structured, ok)
+
+		if ok && rejectSchema {
+			http.Error(w, `{"error": {"message": "Unknown parameter: response_format"}}`, http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, `{"error": {"message": "bad request"}}`, status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]string{"role": "assistant", "content": card}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &structured
+}
+
+func TestOpenAIFallsBackWithoutStructuredOutput(t *testing.T) {
+	server, structured := fakeChatServer(t, "```json\n{\"base_d\": \"Haus\"}\n```", http.StatusOK, true)
+	generator := &OpenAI{URL: server.URL}
+
+	for range 2 {
+		card, err := generator.Generate(context.Background(), Request{Word: "Haus"})
+		if err != nil {
+			t.Fatalf("Generate() = %v", err)
+		}
+		if card.BaseDeutsch != "Haus" {
+			t.Errorf("Generate() = %+v, want base_d Haus", card)
+		}
+	}
+
+	// the second card isn't asked for with a schema again
+	if want := []bool{true, false, false}; !slices.Equal(*structured, want) {
+		t.Errorf("requests with response_format = %v, want %v", *structured, want)
+	}
+}
+
+func TestOpenAIStructuredOutput(t *testing.T) {
+	server, structured := fakeChatServer(t, `{"base_d": "Haus"}`, http.StatusOK, false)
+	generator := &OpenAI{URL: server.URL + "/"}
+
+	if _, err := generator.Generate(context.Background(), Request{Word: "Haus"}); err != nil {
+		t.Fatalf("Generate() = %v", err)
+	}
+	if want := []bool{true}; !slices.Equal(*structured, want) {
+		t.Errorf("requests with response_format = %v, want %v", *structured, want)
+	}
+}
+
+func TestOpenAIOtherClientErrorsAreNotRetried(t *testing.T) {
+	server, structured := fakeChatServer(t, "", http.StatusBadRequest, false)
+	generator := &OpenAI{URL: server.URL}
+
+	_, err := generator.Generate(context.Background(), Request{Word: "Haus"})
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request") {
+		t.Fatalf("Generate() = %v, want the 400 of the server", err)
+	}
+	if len(*structured) != 1 {
+		t.Errorf("sent %d requests, want 1", len(*structured))
+	}
+}
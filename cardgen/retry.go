@@ -0,0 +1,126 @@
+package cardgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryableError is returned when a provider is rate limited or temporarily unavailable (HTTP 429 or 503),
+// so the same request can succeed later
+type RetryableError struct {
+	StatusCode int
+	// RetryAfter is how long the provider asked to wait, 0 when it didn't say
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether a response status means that the same request can succeed later
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// RetryPolicy is how often and how long to wait before a request that failed with a RetryableError is sent again
+type RetryPolicy struct {
+	MaxAttempts int           // including the first request, 1 or less never retries
+	BaseDelay   time.Duration // the delay before the first retry, doubled for every further retry
+	MaxDelay    time.Duration // the longest delay, also for delays that the provider asked for
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    2 * time.Minute,
+}
+
+// delay returns how long to wait before retry number attempt (starting at 1). It is the delay the provider asked
+// for, or exponential backoff otherwise, both with up to 20% random jitter so that concurrent runs spread out.
+func (p RetryPolicy) delay(attempt int, err *RetryableError) time.Duration {
+	delay := err.RetryAfter
+	if delay <= 0 {
+		delay = p.BaseDelay << (attempt - 1)
+	}
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		// delay <= 0 when the shift overflowed
+		delay = p.MaxDelay
+	}
+	return delay + rand.N(delay/5+1)
+}
+
+type retryingGenerator struct {
+	generator CardGenerator
+	policy    RetryPolicy
+}
+
+// WithRetry returns a CardGenerator that retries requests that fail with a RetryableError, following policy.
+// Other errors are returned right away.
+func WithRetry(generator CardGenerator, policy RetryPolicy) CardGenerator {
+	return &retryingGenerator{generator: generator, policy: policy}
+}
+
+func (r *retryingGenerator) Generate(ctx context.Context, request Request) (Card, error) {
+	for attempt := 1; ; attempt++ {
+		card, err := r.generator.Generate(ctx, request)
+		var retryableErr *RetryableError
+		if !errors.As(err, &retryableErr) {
+			return card, err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			return card, fmt.Errorf("%s: gave up after %d attempts: %w", r.generator.Name(), attempt, err)
+		}
+
+		delay := r.policy.delay(attempt, retryableErr)
+		log.Printf("%s returned %d, retrying in %v (attempt %d of %d)", r.generator.Name(), retryableErr.StatusCode,
+			delay.Round(time.Second), attempt+1, r.policy.MaxAttempts)
+		select {
+		case <-ctx.Done():
+			return card, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *retryingGenerator) Name() string {
+	return r.generator.Name()
+}
+
+// geminiRetryDelay reads the delay Gemini asks for from the RetryInfo in the details of an error, e.g.
+// {"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "13s"}
+func geminiRetryDelay(details []map[string]any) time.Duration {
+	for _, detail := range details {
+		if kind, _ := detail["@type"].(string); !strings.HasSuffix(kind, "google.rpc.RetryInfo") {
+			continue
+		}
+		value, _ := detail["retryDelay"].(string)
+		delay, err := time.ParseDuration(value)
+		if err == nil {
+			return delay
+		}
+	}
+	return 0
+}
+
+// retryAfter reads a Retry-After header, in seconds or as a date
+func retryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package cardgen
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flakyGenerator fails with errs, one per request, before it returns a card
+type flakyGenerator struct {
+	errs     []error
+	requests int
+}
+
+func (f *flakyGenerator) Generate(ctx context.Context, request Request) (Card, error) {
+	f.requests++
+	if f.requests <= len(f.errs) {
+		return Card{}, f.errs[f.requests-1]
+	}
+	return Card{BaseDeutsch: request.Word}, nil
+}
+
+func (f *flakyGenerator) Name() string {
+	return "flaky"
+}
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestWithRetry(t *testing.T) {
+	rateLimited := &RetryableError{StatusCode: http.StatusTooManyRequests, Err: errors.New("429 Too Many Requests")}
+	otherErr := errors.New("400 Bad Request")
+	tests := []struct {
+		name         string
+		errs         []error
+		wantErr      error
+		wantRequests int
+	}{
+		{"first request", nil, nil, 1},
+		{"rate limited once", []error{rateLimited}, nil, 2},
+		{"rate limited until the last attempt", []error{rateLimited, rateLimited}, nil, 3},
+		{"gives up", []error{rateLimited, rateLimited, rateLimited}, rateLimited, 3},
+		{"other errors aren't retried", []error{otherErr}, otherErr, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flaky := &flakyGenerator{errs: test.errs}
+			card, err := WithRetry(flaky, testRetryPolicy).Generate(context.Background(), Request{Word: "Haus"})
+			if test.wantErr == nil && (err != nil || card.BaseDeutsch != "Haus") {
+				t.Errorf("Generate() = %+v, %v, want the card of Haus", card, err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("Generate() = %v, want %v", err, test.wantErr)
+			}
+			if flaky.requests != test.wantRequests {
+				t.Errorf("sent %d requests, want %d", flaky.requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{"first retry", 1, 0, time.Second},
+		{"backoff", 3, 0, 4 * time.Second},
+		{"longest delay", 10, 0, 10 * time.Second},
+		{"overflow", 100, 0, 10 * time.Second},
+		{"asked for", 1, 3 * time.Second, 3 * time.Second},
+		{"asked for too long", 1, time.Hour, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delay := policy.delay(test.attempt, &RetryableError{RetryAfter: test.retryAfter})
+			// up to 20% jitter
+			if delay < test.want || delay > test.want+test.want/5 {
+				t.Errorf("delay() = %v, want %v plus up to 20%%", delay, test.want)
+			}
+		})
+	}
+}
+
+func TestGeminiRetryDelay(t *testing.T) {
+	details := []map[string]any{
+		{"@type": "type.googleapis.com/google.rpc.QuotaFailure"},
+		{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "13s"},
+	}
+	if delay := geminiRetryDelay(details); delay != 13*time.Second {
+		t.Errorf("geminiRetryDelay() = %v, want 13s", delay)
+	}
+	if delay := geminiRetryDelay(details[:1]); delay != 0 {
+		t.Errorf("geminiRetryDelay() without RetryInfo = %v, want 0", delay)
+	}
+}
+
+func TestOpenAIRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		http.Error(w, `{"error": {"message": "rate limited"}}`, http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	generator := &OpenAI{URL: server.URL}
+
+	_, err := generator.Generate(context.Background(), Request{Word: "Haus"})
+	var retryableErr *RetryableError
+	if !errors.As(err, &retryableErr) || retryableErr.StatusCode != http.StatusTooManyRequests || retryableErr.RetryAfter != 7*time.Second {
+		t.Errorf("Generate() = %#v, want a *RetryableError with the Retry-After of the server", err)
+	}
+}
@@ -0,0 +1,186 @@
+package cardgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ValidationError is returned for a card that breaks the rules of the prompt, see Validate
+type ValidationError struct {
+	Card     Card
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid card: " + strings.Join(e.Problems, "; ")
+}
+
+// articles are the valid values of artikel_d
+var articles = []string{"der", "die", "das"}
+
+// Validate checks that a card follows the rules of the prompt. It returns a *ValidationError listing every problem.
+func Validate(card Card) error {
+	var problems []string
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	required := []struct{ field, value string }{
+		{"base_d", card.BaseDeutsch},
+		{"full_d", card.FullDeutsch},
+		{"base_e", card.BaseEnglish},
+		{"s1", card.S1},
+		{"s1e", card.S1e},
+		{"s2", card.S2},
+		{"s2e", card.S2e},
+	}
+	for _, field := range required {
+		if strings.TrimSpace(field.value) == "" {
+			problem("%s is empty", field.field)
+		}
+	}
+
+	for _, pair := range [][2]string{{card.S3, card.S3e}, {card.S4, card.S4e}} {
+		if (pair[0] == "") != (pair[1] == "") {
+			problem("an example sentence and its translation must both be set or both be empty: %q, %q", pair[0], pair[1])
+		}
+	}
+	if card.S4 != "" && card.S3 == "" {
+		problem("s4 is set but s3 is empty")
+	}
+
+	full := strings.TrimSpace(card.FullDeutsch)
+	if card.ArticleDeutsch != "" || card.PluralDeutsch != "" {
+		// a noun
+		if !slices.Contains(articles, card.ArticleDeutsch) {
+			problem("artikel_d is %q, expected der, die or das", card.ArticleDeutsch)
+		}
+		if card.PluralDeutsch == "" {
+			problem(`plural_d of a noun is empty, expected the plural ending, e.g. "-e", or "-" when it doesn't change`)
+		}
+		expected := fmt.Sprintf("%s %s, %s", card.ArticleDeutsch, card.BaseDeutsch, card.PluralDeutsch)
+		if full != expected {
+			problem("full_d is %q, expected artikel_d, base_d and plural_d: %q", full, expected)
+		}
+	} else if strings.Contains(full, ",") {
+		// a verb
+		forms := strings.Split(full, ",")
+		if len(forms) != 4 || slices.ContainsFunc(forms, func(form string) bool { return strings.TrimSpace(form) == "" }) {
+			problem("full_d of a verb has %d forms, expected infinitive, present, simple past and present perfect, e.g. %q",
+				len(forms), "analysieren, analysiert, analysierte, hat analysiert")
+		}
+	}
+
+	if strings.HasPrefix(card.BaseDeutsch, "sich ") != strings.HasPrefix(full, "sich ") {
+		problem(`base_d and full_d of a reflexive verb must both start with "sich": %q, %q`, card.BaseDeutsch, full)
+	}
+
+	stems := wordStems(card)
+	for _, sentence := range []struct{ field, text string }{{"s1", card.S1}, {"s2", card.S2}, {"s3", card.S3}, {"s4", card.S4}} {
+		if sentence.text != "" && !mentionsWord(sentence.text, stems) {
+			problem("%s doesn't use the word %q: %q", sentence.field, card.BaseDeutsch, sentence.text)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Card: card, Problems: problems}
+	}
+	return nil
+}
+
+// GenerateValid generates the card of a word and validates it. An invalid card is sent back to the model with its
+// problems, up to retries times. When it's still invalid, the *ValidationError holds the last card.
+func GenerateValid(ctx context.Context, generator CardGenerator, word string, retries int) (Card, error) {
+	request := Request{Word: word}
+	for attempt := 0; ; attempt++ {
+		card, err := generator.Generate(ctx, request)
+		if err != nil {
+			return Card{}, err
+		}
+
+		err = Validate(card)
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || attempt >= retries {
+			return card, err
+		}
+
+		log.Printf("asking %s to fix the card of %s: %v", generator.Name(), word, err)
+		request.Previous = &card
+		request.Problems = invalid.Problems
+	}
+}
+
+// ignoredForms are the words of full_d that aren't forms of the word itself
+var ignoredForms = []string{"der", "die", "das", "sich", "hat", "ist"}
+
+// minStemLength is the length of the shortest stem, shorter ones would match almost any sentence
+const minStemLength = 3
+
+// wordStems returns the stems of the forms of the card's word in lower case, including umlauted stems for plurals
+// and comparatives, e.g. "haus" and "häus" for "das Haus, -¨er", or "benehm" and "benimmt" for a verb
+func wordStems(card Card) []string {
+	var forms []string
+	for _, form := range strings.Split(card.FullDeutsch, ",") {
+		words := slices.DeleteFunc(strings.Fields(form), func(word string) bool {
+			return strings.HasPrefix(word, "-") || slices.Contains(ignoredForms, strings.ToLower(word))
+		})
+		if len(words) == 2 {
+			// a separable verb, e.g. "fängt an": the particle only counts joined with the verb, e.g. "anfängt",
+			// because on its own it would match almost any sentence
+			words = []string{words[0], words[1] + words[0]}
+		}
+		forms = append(forms, words...)
+	}
+	for _, form := range strings.Fields(card.BaseDeutsch) {
+		if form != "sich" {
+			forms = append(forms, form)
+		}
+	}
+
+	var stems []string
+	for _, form := range forms {
+		stem := stemOf(strings.ToLower(strings.TrimFunc(form, func(r rune) bool { return !unicode.IsLetter(r) })))
+		if len([]rune(stem)) < minStemLength {
+			continue
+		}
+		stems = append(stems, stem, umlaut(stem))
+	}
+	return stems
+}
+
+// stemOf removes a common ending from a word, as long as enough of the word remains to be recognizable
+func stemOf(word string) string {
+	for _, ending := range []string{"en", "e", "n"} {
+		stem, ok := strings.CutSuffix(word, ending)
+		if ok && len([]rune(stem)) >= 4 {
+			return stem
+		}
+	}
+	return word
+}
+
+// umlaut replaces the last a, o, u or au of a stem with its umlaut
+func umlaut(stem string) string {
+	i := strings.LastIndexAny(stem, "aou")
+	switch {
+	case i < 0:
+		return stem
+	case stem[i] == 'u' && i > 0 && stem[i-1] == 'a':
+		return stem[:i-1] + "äu" + stem[i+1:]
+	default:
+		return stem[:i] + map[byte]string{'a': "ä", 'o': "ö", 'u': "ü"}[stem[i]] + stem[i+1:]
+	}
+}
+
+// mentionsWord reports whether a sentence contains one of the stems, also as part of a compound word
+func mentionsWord(sentence string, stems []string) bool {
+	sentence = strings.ToLower(sentence)
+	return slices.ContainsFunc(stems, func(stem string) bool {
+		return strings.Contains(sentence, stem)
+	})
+}
@@ -0,0 +1,247 @@
+package cardgen
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// validNoun and validVerb are cards that follow every rule, the test cases break one rule at a time
+var (
+	validNoun = Card{
+		FullDeutsch:    "das Haus, -¨er",
+		BaseDeutsch:    "Haus",
+		BaseEnglish:    "house",
+		ArticleDeutsch: "das",
+		PluralDeutsch:  "-¨er",
+		S1:             "Das Haus ist alt.",
+		S1e:            "The house is old.",
+		S2:             "Die Häuser sind neu.",
+		S2e:            "The houses are new.",
+	}
+	validVerb = Card{
+		FullDeutsch: "anfangen, fängt an, fing an, hat angefangen",
+		BaseDeutsch: "anfangen",
+		BaseEnglish: "to begin",
+		S1:          "Der Film fängt um acht an.",
+		S1e:         "The film begins at eight.",
+		S2:          "Wir haben schon angefangen.",
+		S2e:         "We have already begun.",
+		S3:          "Sie fing sofort an.",
+		S3e:         "She began right away.",
+	}
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		card   Card
+		change func(card *Card)
+		// problems are substrings of the expected problems, in order. none means that the card is valid
+		problems []string
+	}{
+		{name: "valid noun", card: validNoun},
+		{name: "valid verb", card: validVerb},
+		{
+			name: "valid reflexive verb",
+			card: Card{
+				FullDeutsch: "sich amüsieren, amüsiert sich, amüsierte sich, hat sich amüsiert",
+				BaseDeutsch: "sich amüsieren",
+				BaseEnglish: "to have fun",
+				S1:          "Wir haben uns auf der Party gut amüsiert.",
+				S1e:         "We had a good time at the party.",
+				S2:          "Er amüsiert sich über den Witz.",
+				S2e:         "He is amused by the joke.",
+			},
+		},
+		{
+			name:     "empty required field",
+			card:     validNoun,
+			change:   func(card *Card) { card.BaseEnglish = " " },
+			problems: []string{"base_e is empty"},
+		},
+		{
+			name:     "sentence without translation",
+			card:     validNoun,
+			change:   func(card *Card) { card.S3 = "Im Haus ist es warm." },
+			problems: []string{"both be set or both be empty"},
+		},
+		{
+			name: "s4 without s3",
+			card: validNoun,
+			change: func(card *Card) {
+				card.S4, card.S4e = "Im Haus ist es warm.", "It's warm in the house."
+			},
+			problems: []string{"s4 is set but s3 is empty"},
+		},
+		{
+			name: "wrong article",
+			card: validNoun,
+			change: func(card *Card) {
+				card.ArticleDeutsch = "the"
+				card.FullDeutsch = "the Haus, -¨er"
+			},
+			problems: []string{`artikel_d is "the"`},
+		},
+		{
+			name:     "noun without plural",
+			card:     validNoun,
+			change:   func(card *Card) { card.PluralDeutsch, card.FullDeutsch = "", "das Haus, " },
+			problems: []string{"plural_d of a noun is empty", "full_d is"},
+		},
+		{
+			name:     "full_d doesn't match the noun",
+			card:     validNoun,
+			change:   func(card *Card) { card.FullDeutsch = "das Haus" },
+			problems: []string{`expected artikel_d, base_d and plural_d: "das Haus, -¨er"`},
+		},
+		{
+			name: "verb with three forms",
+			card: validVerb,
+			change: func(card *Card) {
+				card.FullDeutsch = "anfangen, fängt an, hat angefangen"
+				card.S3, card.S3e = "", ""
+			},
+			problems: []string{"full_d of a verb has 3 forms"},
+		},
+		{
+			name: "verb with an empty form",
+			card: validVerb,
+			change: func(card *Card) {
+				card.FullDeutsch = "anfangen, fängt an, , hat angefangen"
+				card.S3, card.S3e = "", ""
+			},
+			problems: []string{"full_d of a verb has 4 forms"},
+		},
+		{
+			name:     "reflexive verb without sich in full_d",
+			card:     validVerb,
+			change:   func(card *Card) { card.BaseDeutsch = "sich anfangen" },
+			problems: []string{`must both start with "sich"`},
+		},
+		{
+			name:     "sentence without the word",
+			card:     validVerb,
+			change:   func(card *Card) { card.S3 = "Sie kam an." },
+			problems: []string{"s3 doesn't use the word"},
+		},
+		{
+			name:     "separable particle alone isn't the word",
+			card:     validVerb,
+			change:   func(card *Card) { card.S2 = "Wir kommen morgen an." },
+			problems: []string{"s2 doesn't use the word"},
+		},
+		{
+			name:   "word in a compound",
+			card:   validNoun,
+			change: func(card *Card) { card.S2 = "Das Hochhaus ist neu." },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			card := test.card
+			if test.change != nil {
+				test.change(&card)
+			}
+
+			err := Validate(card)
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			if len(invalid.Problems) != len(test.problems) {
+				t.Fatalf("Validate() problems = %q, want %d problems", invalid.Problems, len(test.problems))
+			}
+			for i, problem := range test.problems {
+				if !strings.Contains(invalid.Problems[i], problem) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, invalid.Problems[i], problem)
+				}
+			}
+		})
+	}
+}
+
+func TestWordStems(t *testing.T) {
+	tests := []struct {
+		name string
+		card Card
+		want []string
+	}{
+		{
+			name: "noun with umlaut plural",
+			card: validNoun,
+			want: []string{"haus", "häus"},
+		},
+		{
+			name: "separable verb",
+			card: validVerb,
+			want: []string{"anfang", "anfäng", "fängt", "anfängt", "fing", "anfing", "angefang"},
+		},
+		{
+			name: "reflexive verb",
+			card: Card{FullDeutsch: "sich amüsieren, amüsiert sich, amüsierte sich, hat sich amüsiert", BaseDeutsch: "sich amüsieren"},
+			want: []string{"amüsier", "amüsiert"},
+		},
+		{
+			name: "short stems are dropped",
+			card: Card{FullDeutsch: "das Ei, -er", BaseDeutsch: "Ei"},
+		},
+		{
+			name: "three letter stems are kept",
+			card: Card{FullDeutsch: "tun, tut, tat, hat getan", BaseDeutsch: "tun"},
+			want: []string{"tun", "tut", "tat", "tät"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := wordStems(test.card)
+			if len(test.want) == 0 && len(got) > 0 {
+				t.Errorf("wordStems() = %q, want none", got)
+			}
+			for _, stem := range test.want {
+				if !slices.Contains(got, stem) {
+					t.Errorf("wordStems() = %q, missing %q", got, stem)
+				}
+			}
+			for _, stem := range got {
+				if len([]rune(stem)) < minStemLength {
+					t.Errorf("wordStems() = %q, has the short stem %q", got, stem)
+				}
+			}
+			if slices.Contains(got, "an") {
+				t.Errorf("wordStems() = %q, has the particle %q", got, "an")
+			}
+		})
+	}
+}
+
+func TestUmlaut(t *testing.T) {
+	tests := []struct {
+		stem, want string
+	}{
+		{"haus", "häus"},
+		{"mann", "männ"},
+		{"sohn", "söhn"},
+		{"buch", "büch"},
+		{"baum", "bäum"},
+		{"hand", "händ"},
+		{"fing", "fing"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := umlaut(test.stem); got != test.want {
+			t.Errorf("umlaut(%q) = %q, want %q", test.stem, got, test.want)
+		}
+	}
+}
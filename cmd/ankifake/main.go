@@ -0,0 +1,58 @@
+package main
+
+import (
+	"anki-voice/ankiconnect/ankifake"
+	"flag"
+	"log"
+	"net/http"
+)
+
+// the note type used by the German vocabulary deck
+var vocabFields = []string{
+	"full_d", "base_d", "base_e", "artikel_d", "plural_d", "base_a",
+	"s1", "s1e", "s1a", "s2", "s2e", "s2a", "s3", "s3e", "s3a", "s4", "s4e", "s4a",
+	"s5", "s5e", "s5a", "s6", "s6e", "s6a", "s7", "s7e", "s7a", "s8", "s8e", "s8a", "s9", "s9e", "s9a",
+}
+
+// ankifake serves an in-memory AnkiConnect, so the other commands can be tried out without anki
+func main() {
+	addrFlag := flag.String("addr", "localhost:8765", "address to listen on")
+	apiKeyFlag := flag.String("apikey", "", "require this AnkiConnect API key")
+	sampleFlag := flag.Bool("sample", true, "add a few sample notes tagged \"audio\"")
+	flag.Parse()
+
+	fake := ankifake.New()
+	fake.APIKey = *apiKeyFlag
+	fake.AddDeck("B1_Wortliste_DTZ_Goethe")
+	fake.AddModel("Basic (and reversed card)-7c609", vocabFields...)
+
+	if *sampleFlag {
+		addSampleNotes(fake)
+	}
+
+	log.Printf("fake AnkiConnect listening on http://%s", *addrFlag)
+	log.Fatal(http.ListenAndServe(*addrFlag, fake))
+}
+
+func addSampleNotes(fake *ankifake.Fake) {
+	samples := []map[string]string{
+		{
+			"full_d": "das Abgas, -e", "base_d": "Abgas", "base_e": "exhaust gas",
+			"s1": "Die Abgase der Autos verschmutzen die Luft.", "s1e": "The exhaust gases of cars pollute the air.",
+		},
+		{
+			"full_d": "sich amüsieren, amüsiert sich, amüsierte sich, hat sich amüsiert", "base_d": "sich amüsieren", "base_e": "to enjoy oneself",
+			"s1": "Wir haben uns auf der Party gut amüsiert.", "s1e": "We enjoyed ourselves at the party.",
+			"s2": "Er amüsiert sich über den Witz.", "s2e": "He is amused by the joke.",
+		},
+	}
+
+	for _, fields := range samples {
+		fake.AddNote(ankifake.Note{
+			Model:  "Basic (and reversed card)-7c609",
+			Deck:   "B1_Wortliste_DTZ_Goethe",
+			Fields: fields,
+			Tags:   []string{"audio"},
+		})
+	}
+}
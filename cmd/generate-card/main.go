@@ -3,151 +3,268 @@ package main
 import (
 	"anki-voice/anki"
 	"anki-voice/ankiconnect"
+	"anki-voice/audio"
+	"anki-voice/cardgen"
+	"anki-voice/config"
+	"anki-voice/journal"
 	"anki-voice/noteaudio"
+	"anki-voice/review"
 	"context"
 	_ "embed"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
-	"google.golang.org/genai"
 )
 
-// key: field in German, value: audio field
-var audioFields = map[string]string{
-	"base_d": "base_a",
-	"s1":     "s1a",
-	"s2":     "s2a",
-	"s3":     "s3a",
-	"s4":     "s4a",
-}
-
-const PROMPT = `
-Return the following fields in a JSON structure for the word: %s
-The values will be used for creating Anki cards to learn German vocabulary.
-
-* base_d: the base form the German word.
-  * When a noun, omit the article. e.g. "Abgas".
-	* When a reflexive verb, should start with "sich".
-* full_d: German word. 
-  * When a verb, should be a comma separated list of infinitive, present, simple past, and present perfect. e.g. "analysieren, analysiert, analysierte, hat analysiert"
-	* When a reflexive verb, should start with "sich". e.g. "sich amüsieren, amüsiert sich, amüsierte sich, hat sich amüsiert"
-  * When a noun, should include the article, and the ending in plural. e.g. "das Abgas, -e", "das Alter, -". This is just a combination of the fields artikel_d, base_d, and plural_d.
-* base_e: the English translation. e.g. "to analyze"
-  * If an English translation is provided in the prompt, make sure base_e covers what is provided
-* artikel_d:
-  * When a noun, the article. 
-  * When not a noun, blank string
-* plural_d: 
-  * When a noun, the plural ending. "-" if the ending does not change, and e.g. "-e" if an "e" is added.
-  * When not a noun, blank string
-* s1: The first example sentence in German. Create a typical sentence that the word would be used in.
-* s1e: The English translation of s1.
-* s2: The second example sentence in German. If there is more than one meaning of the word, then create a sentence that demonstrates a use of the second meaning.
-* s2e: The English translation of s2.
-* s3: The third example sentence in German. Only include If there are more than two commonly used meanings of the word. Otherwise, leave blank.
-* s3e: The English translation of s3.
-* s4: The fourth example sentence in German. Only include If there are more than three commonly used meanings of the word. Otherwise, leave blank.
-* s4e: The English translation of s4.
-
-Other things to note: 
-* If the word is in plural, convert it to singular
-* Return ONLY the JSON object wrapped in a json code block, and do not include any other content or text.
-`
-
-type GeminiResponse struct {
-	FullDeutsch    string `json:"full_d"`
-	BaseDeutsch    string `json:"base_d"`
-	BaseEnglish    string `json:"base_e"`
-	ArticleDeutsch string `json:"artikel_d"`
-	PluralDeutsch  string `json:"plural_d"`
-	S1             string
-	S1e            string
-	S2             string
-	S2e            string
-	S3             string
-	S3e            string
-	S4             string
-	S4e            string
-}
-
-func (response GeminiResponse) toMap() map[string]string {
-	return map[string]string{
-		"full_d":    response.FullDeutsch,
-		"base_d":    response.BaseDeutsch,
-		"base_e":    response.BaseEnglish,
-		"artikel_d": response.ArticleDeutsch,
-		"plural_d":  response.PluralDeutsch,
-		"s1":        response.S1,
-		"s1e":       response.S1e,
-		"s2":        response.S2,
-		"s2e":       response.S2e,
-		"s3":        response.S3,
-		"s3e":       response.S3e,
-		"s4":        response.S4,
-		"s4e":       response.S4e,
-	}
-}
-
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("no .env file found; using existing environment")
 	}
 
-	GEMINI_API_KEY := os.Getenv("GEMINI_API_KEY")
-	if GEMINI_API_KEY == "" {
-		log.Fatal("GEMINI_API_KEY is not set")
-	}
+	configPathFlag := flag.String("config", "", "config file, defaults to ANKI_VOICE_CONFIG, ./anki-voice.yaml or anki-voice/config.yaml in the user config directory")
+	wordFlag := flag.String("word", "", "word to generate a note for")
+	limitFlag := flag.Int("limit", 50, "maximum number of notes to generate")
+	speakerFlag := flag.String("speaker", os.Getenv("TTS_SPEAKER"), "speaker name or id, for multi-speaker voices")
+	lengthScaleFlag := flag.Float64("lengthscale", 0, "speaking speed, e.g. 1.3 for slower audio. 0 uses the voice default")
+	slowFlag := flag.Float64("slow", 0, "also generate a slowed down recording with this length scale, e.g. 1.5")
+	slowSuffixFlag := flag.String("slowsuffix", "", "write the slow recording to the audio field plus this suffix, e.g. \"_slow\" for s1a_slow. empty appends it to the regular audio field")
+	llmFlag := flag.String("llm", os.Getenv("LLM_PROVIDER"), "model provider that writes the cards: gemini (default), openai for any OpenAI-compatible API, e.g. a local llama.cpp or Ollama server, or fake")
+	llmModelFlag := flag.String("llmmodel", os.Getenv("LLM_MODEL"), "model name, defaults to the provider's default")
+	journalFlag := flag.String("journal", "", "file recording the progress of the run, defaults to generate-card.journal.jsonl in the user cache directory")
+	resumeFlag := flag.Bool("resume", false, "continue the journaled run, e.g. after it was interrupted: skip finished words and add the missing audio of notes that were already added")
+	maxAttemptsFlag := flag.Int("maxattempts", cardgen.DefaultRetryPolicy.MaxAttempts, "attempts to generate a card while the model is rate limited or unavailable, with increasing delays")
+	fixAttemptsFlag := flag.Int("fixattempts", 2, "times an invalid card is sent back to the model with its problems, before it is parked for review")
+	stageFlag := flag.Bool("stage", false, "write the generated cards with their audio to the review directory instead of adding them to anki, see the review command")
+	reviewDirFlag := flag.String("reviewdir", os.Getenv("REVIEW_DIR"), "directory of the cards parked for review, defaults to anki-voice/review in the user data directory")
+	flag.Parse()
 
-	VOCAB_DIR := os.Getenv("VOCAB_DIR")
-	if VOCAB_DIR == "" {
-		log.Fatal("VOCAB_DIR is not set")
+	// the config file fills in what neither flags nor environment variables set
+	configPath, err := config.FindPath(*configPathFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := config.Load(configPath, os.Getenv("ANKI_PROFILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fieldMap, err := cfg.FieldMap(cfg.Model)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *speakerFlag == "" {
+		*speakerFlag = cfg.TTS.Speaker
+	}
+	if *lengthScaleFlag == 0 {
+		*lengthScaleFlag = cfg.TTS.LengthScale
 	}
 
 	// general setup
 	ctx := context.Background()
-	ankiMediaDir, err := anki.MediaDir()
+	ankiClient := ankiconnect.NewClient(ankiconnect.Config{
+		URL:     envOr("ANKICONNECT_URL", cfg.AnkiConnect.URL),
+		APIKey:  envOr("ANKICONNECT_API_KEY", cfg.AnkiConnect.APIKey),
+		Timeout: cfg.AnkiConnect.Timeout,
+	})
+
+	// ANKI_MEDIA_STORE=ankiconnect uploads audio through AnkiConnect instead of writing into the media folder
+	mediaStoreKind := envOr("ANKI_MEDIA_STORE", cfg.Media.Store)
+	if mediaStoreKind == "" {
+		mediaStoreKind = noteaudio.MediaStoreDir
+	}
+	// ANKI_MEDIA_DIR or ANKI_PROFILE select the media folder, when it's not the one of the profile anki loaded last
+	mediaStore, err := noteaudio.NewMediaStore(mediaStoreKind, ankiClient, anki.MediaConfig{
+		Dir:     envOr("ANKI_MEDIA_DIR", cfg.Media.Dir),
+		BaseDir: envOr("ANKI_BASE", cfg.Media.Base),
+		Profile: envOr("ANKI_PROFILE", cfg.Media.Profile),
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Gemini setup
-	geminiClient, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: GEMINI_API_KEY})
+	// TTS_BACKEND selects piper-http (default), piper or espeak-ng
+	synthesizer, err := audio.New(audio.Config{
+		Backend: envOr("TTS_BACKEND", cfg.TTS.Backend),
+		URL:     envOr("TTS_URL", cfg.TTS.URL),
+		API:     envOr("PIPER_API", cfg.TTS.API),
+		Binary:  envOr("TTS_BINARY", cfg.TTS.Binary),
+		Voice:   envOr("TTS_VOICE", cfg.TTS.Voice),
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// AUDIO_CACHE_DIR overrides where generated audio is cached
+	cacheDir := os.Getenv("AUDIO_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir, err = audio.DefaultCacheDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	cache := &audio.Cache{Dir: cacheDir}
+
 	// check that anki is running
-	_, err = ankiconnect.QueryNotes("test")
+	_, err = ankiClient.QueryNotes(ctx, "test")
+	if errors.Is(err, ankiconnect.ErrConnectionRefused) {
+		log.Fatalf("could not connect to anki at %s, is anki running?\n%s", ankiClient.URL(), err)
+	}
+	if errors.Is(err, ankiconnect.ErrPermissionDenied) {
+		log.Fatalf("anki connect denied the request, check ANKICONNECT_API_KEY\n%s", err)
+	}
 	if err != nil {
-		log.Fatalf("error response from anki, is anki running?\n%s", err)
+		log.Fatalf("error response from anki\n%s", err)
 	}
 
-	wordFlag := flag.String("word", "", "word to generate a note for")
-	limitFlag := flag.Int("limit", 50, "maximum number of notes to generate")
-	flag.Parse()
-
 	word := *wordFlag
 	limit := *limitFlag
+	voice := audio.VoiceOptions{
+		Speaker:     *speakerFlag,
+		LengthScale: *lengthScaleFlag,
+	}
+	var variants []noteaudio.Variant
+	if *slowFlag != 0 {
+		slowVoice := voice
+		slowVoice.LengthScale = *slowFlag
+		variants = append(variants, noteaudio.Variant{Name: "slow", FieldSuffix: *slowSuffixFlag, Voice: slowVoice})
+	}
+
+	reviewDir := *reviewDirFlag
+	if reviewDir == "" {
+		reviewDir, err = review.DefaultDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	g := &generator{
+		ankiClient:  ankiClient,
+		mediaStore:  mediaStore,
+		deck:        cfg.Deck,
+		model:       cfg.Model,
+		fieldMap:    fieldMap,
+		tags:        cfg.Tags,
+		fixAttempts: *fixAttemptsFlag,
+		review:      &review.Store{Dir: reviewDir},
+		stage:       *stageFlag,
+		audioOptions: noteaudio.Options{
+			Overwrite:   true,
+			Synthesizer: audio.LimitSynthesizer(synthesizer, audio.DefaultMaxSyntheses),
+			Voice:       voice,
+			Variants:    variants,
+			Cache:       cache,
+			Encoders:    audio.NewEncoders(runtime.NumCPU()),
+			WorkDir:     os.Getenv("AUDIO_WORK_DIR"),
+		},
+	}
+
+	// check the note type before any note is generated
+	fieldNames, err := ankiClient.ModelFieldNames(ctx, g.model)
+	if err != nil {
+		log.Fatalf("note type %q: %v", g.model, err)
+	}
+	if err := noteaudio.CheckFields(fieldNames, g.fieldMap, g.audioOptions); err != nil {
+		log.Fatalf("note type %q doesn't have the configured fields: %v", g.model, err)
+	}
+
+	if flag.Arg(0) == "review" {
+		if err := g.runReviewCommand(ctx, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// the model that writes the cards, gemini unless -llm, LLM_PROVIDER or the config select another one
+	llm := cfg.LLM
+	if *llmFlag != "" {
+		llm.Provider = *llmFlag
+	}
+	if *llmModelFlag != "" {
+		llm.Model = *llmModelFlag
+	}
+	apiKeyEnv := "GEMINI_API_KEY"
+	if llm.Provider == cardgen.ProviderOpenAI {
+		apiKeyEnv = "OPENAI_API_KEY"
+	}
+	cardGenerator, err := cardgen.New(ctx, cardgen.Config{
+		Provider:   llm.Provider,
+		Model:      llm.Model,
+		URL:        envOr("OPENAI_BASE_URL", llm.URL),
+		APIKey:     envOr(apiKeyEnv, llm.APIKey),
+		FixtureDir: llm.Fixtures,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("generating cards with %s", cardGenerator.Name())
+	if llm.Provider == cardgen.ProviderFake {
+		g.tags.Generated = append(slices.Clone(g.tags.Generated), cardgen.FakeTag)
+		log.Printf("warning: the fake provider makes placeholder cards, delete them from a real collection with the query tag:%s", cardgen.FakeTag)
+	}
+	retryPolicy := cardgen.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = *maxAttemptsFlag
+	cardGenerator = cardgen.WithRetry(cardGenerator, retryPolicy)
+
+	journalPath := *journalFlag
+	if journalPath == "" {
+		journalPath, err = journal.DefaultPath("generate-card")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	runJournal, err := journal.Open(journalPath, *resumeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := runJournal.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	g.cards = cardGenerator
+	g.journal = runJournal
+	g.stopping = journal.StopOnInterrupt("word")
 
 	if word == "" {
-		generateNoteForWordsInVocabDir(geminiClient, ankiMediaDir, VOCAB_DIR, limit)
-	} else {
-		generateNote(word, geminiClient, ankiMediaDir)
+		vocabDir := os.Getenv("VOCAB_DIR")
+		if vocabDir == "" {
+			log.Fatal("VOCAB_DIR is not set")
+		}
+		g.generateNoteForWordsInVocabDir(ctx, vocabDir, limit)
+	} else if err := g.generateNote(ctx, word); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func generateNoteForWordsInVocabDir(geminiClient *genai.Client, ankiMediaDir string, vocabDir string, limit int) {
+// generator holds the clients needed to turn a word into an anki note with audio
+type generator struct {
+	ankiClient   *ankiconnect.Client
+	cards        cardgen.CardGenerator
+	mediaStore   noteaudio.MediaStore
+	audioOptions noteaudio.Options
+	journal      *journal.Journal
+	stopping     <-chan struct{} // closed when the run should stop after the current word
+	deck         string
+	model        string
+	fieldMap     map[string]string // key: field with text, value: audio field
+	tags         config.Tags
+	fixAttempts  int           // see cardgen.GenerateValid
+	review       *review.Store // where invalid cards are parked, and all cards are staged with stage
+	stage        bool
+}
+
+func (g *generator) generateNoteForWordsInVocabDir(ctx context.Context, vocabDir string, limit int) {
 	entries, err := vocabEntriesFromDir(vocabDir)
 	if err != nil {
 		log.Fatal(err)
@@ -155,27 +272,34 @@ func generateNoteForWordsInVocabDir(geminiClient *genai.Client, ankiMediaDir str
 
 	count := 0
 	for _, entry := range entries {
-		generateErr := generateNote(entry.word, geminiClient, ankiMediaDir)
-
-		var apiErr *genai.APIError
-		if errors.As(err, apiErr) {
-			detailsStr := fmt.Sprint("%v", apiErr.Details)
+		select {
+		case <-g.stopping:
+			log.Println("stopped, continue the run with -resume")
+			return
+		default:
+		}
 
-			delay, err := extractRetryDelay(detailsStr)
-			if err != nil{
-				log.Fatalf("failed to extract retry delay. original gemini error:\n%s\n\nextract error:\n%s\n", generateErr, err)
+		if g.journal.Done(entry.word) {
+			// the resumed run may have been interrupted before it deleted the vocab file
+			log.Printf("skipping %s, finished by the resumed run", entry.word)
+			if err := os.Remove(entry.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatalf("failed to delete vocab file %s: %v", entry.path, err)
 			}
+			continue
+		}
 
-			log.Printf("retry delay: %v", delay)
-			time.Sleep(delay)
-
-			// retry after delay, this time fail if error is returned
-			err = generateNote(entry.word, geminiClient, ankiMediaDir)
-			if err != nil {
-				log.Fatal(err)
+		if err := g.generateNote(ctx, entry.word); err != nil {
+			// the vocab file is kept, so the word is tried again by the next run
+			log.Printf("failed to generate a note for %s: %v", entry.word, err)
+			var retryableErr *cardgen.RetryableError
+			if errors.As(err, &retryableErr) || errors.Is(err, ankiconnect.ErrConnectionRefused) {
+				// the next words would fail the same way
+				log.Fatal("stopping, run again later")
 			}
+			continue
 		}
 
+		// the note was added, was already in anki, or the card is waiting in the review directory
 		if err := os.Remove(entry.path); err != nil {
 			log.Fatalf("failed to delete vocab file %s: %v", entry.path, err)
 		}
@@ -188,72 +312,155 @@ func generateNoteForWordsInVocabDir(geminiClient *genai.Client, ankiMediaDir str
 	}
 }
 
-func generateNote(word string, geminiClient *genai.Client, ankiMediaDir string) error {
-	// retrieve result from Gemini
-	result, err := geminiClient.Models.GenerateContent(
-		context.Background(),
-		"gemini-2.5-flash",
-		genai.Text(fmt.Sprintf(PROMPT, word)),
-		nil,
-	)
+func (g *generator) generateNote(ctx context.Context, word string) error {
+	if last, ok := g.journal.Last(word); ok {
+		switch {
+		case last.Step == journal.StepDone:
+			log.Printf("skipping %s, finished by the resumed run", word)
+			return nil
+		case last.NoteID != 0:
+			// the resumed run already added the note, only its audio is missing
+			log.Printf("resuming note %d of %s", last.NoteID, word)
+			return g.finishNote(ctx, word, last.NoteID)
+		}
+	}
+
+	card, err := cardgen.GenerateValid(ctx, g.cards, word, g.fixAttempts)
+	var invalid *cardgen.ValidationError
+	if errors.As(err, &invalid) {
+		return g.stageCard(ctx, word, invalid.Card, invalid.Problems)
+	}
 	if err != nil {
 		return err
 	}
-	log.Printf("Gemini response: \n%s\n", result.Text())
 
-	// remove the code block that Gemini prefers to add to the response
-	jsonText := result.Text()
-	jsonText = strings.TrimPrefix(jsonText, "```json")
-	jsonText = strings.TrimSuffix(jsonText, "```")
+	if g.stage {
+		return g.stageCard(ctx, word, card, nil)
+	}
+	return g.addCard(ctx, word, card)
+}
 
-	// unmarshal the JSON that was in the code block
-	var response GeminiResponse
-	err = json.Unmarshal([]byte(jsonText), &response)
+// addCard adds the note of a card and its audio. A duplicate of a note that is already in anki is skipped.
+func (g *generator) addCard(ctx context.Context, word string, card cardgen.Card) error {
+	noteID, err := g.addNote(ctx, word, card)
+	if errors.Is(err, ankiconnect.ErrDuplicateNote) {
+		log.Println("skipping duplicate note")
+		g.journal.Record(journal.Entry{Item: word, Step: journal.StepDone})
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// add the note
+	return g.finishNote(ctx, word, noteID)
+}
+
+// addNote adds the note of a card, without audio, see finishNote
+func (g *generator) addNote(ctx context.Context, word string, card cardgen.Card) (int, error) {
 	log.Println("Adding note...")
-	noteID, err := ankiconnect.AddNote(response.toMap())
+	noteID, err := g.ankiClient.AddNote(ctx, ankiconnect.NewNote{
+		Deck:   g.deck,
+		Model:  g.model,
+		Fields: card.Fields(),
+		Tags:   g.tags.Generated,
+	})
+	if errors.Is(err, ankiconnect.ErrDuplicateNote) {
+		return 0, err
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "cannot create note because it is a duplicate") {
-			log.Println("skipping duplicate note")
-			return nil
-		} else {
-			return err
-		}
+		g.journal.Record(journal.Entry{Item: word, Step: journal.StepFailed, Error: err.Error()})
+		return 0, err
 	}
 	log.Printf("Added note: %d", noteID)
+	g.journal.Record(journal.Entry{Item: word, Step: "note", NoteID: noteID})
+
+	return noteID, nil
+}
+
+// stageCard writes a card and its audio to the review store instead of adding it to anki: cards that are still
+// invalid after the model tried to fix them, with their problems, and all cards with stage
+func (g *generator) stageCard(ctx context.Context, word string, card cardgen.Card, problems []string) error {
+	entry := review.Entry{Word: word, Card: card, Problems: problems}
+	err := g.synthesizeReviewAudio(ctx, &entry)
+	if err == nil {
+		err = g.review.Save(entry)
+	}
+	if err != nil {
+		g.journal.Record(journal.Entry{Item: word, Step: journal.StepFailed, Error: err.Error()})
+		return err
+	}
+
+	if len(problems) > 0 {
+		log.Printf("parked %s for review in %s: %s", word, g.review.Dir, strings.Join(problems, "; "))
+	} else {
+		log.Printf("staged %s for review in %s", word, g.review.Dir)
+	}
+	g.journal.Record(journal.Entry{Item: word, Step: journal.StepDone})
+	return nil
+}
+
+// synthesizeReviewAudio generates the audio of the text fields of a review entry, to listen to during review.
+// It uses the audio cache, so the note's audio is generated from the cache once the card is added.
+func (g *generator) synthesizeReviewAudio(ctx context.Context, entry *review.Entry) error {
+	if err := g.review.ResetAudio(entry.Word); err != nil {
+		return err
+	}
 
-	// add audio to the note
-	addAudioToNote(noteID, ankiMediaDir)
+	fields := entry.Card.Fields()
+	textFields := make([]string, 0, len(g.fieldMap))
+	for field := range g.fieldMap {
+		textFields = append(textFields, field)
+	}
+	sort.Strings(textFields)
+
+	entry.Audio = make(map[string]string)
+	for _, field := range textFields {
+		text := strings.TrimSpace(fields[field])
+		if text == "" {
+			continue
+		}
+
+		filename := field + ".mp3"
+		err := g.audioOptions.Cache.GenerateMP3(ctx, g.audioOptions.Synthesizer, g.audioOptions.Encoders, text, g.review.AudioPath(entry.Word, filename), g.audioOptions.Voice)
+		if err != nil {
+			return fmt.Errorf("audio of %s: %w", field, err)
+		}
+		entry.Audio[field] = filename
+	}
+	return nil
+}
+
+// finishNote adds audio to a note that was added for word
+func (g *generator) finishNote(ctx context.Context, word string, noteID int) error {
+	if err := g.addAudioToNote(ctx, noteID); err != nil {
+		g.journal.Record(journal.Entry{Item: word, Step: journal.StepFailed, NoteID: noteID, Error: err.Error()})
+		return err
+	}
 	log.Printf("Added audio to note: %d", noteID)
+	g.journal.Record(journal.Entry{Item: word, Step: journal.StepDone, NoteID: noteID})
 
 	return nil
 }
 
-func addAudioToNote(noteID int, ankiMediaDir string) error {
+func (g *generator) addAudioToNote(ctx context.Context, noteID int) error {
 	log.Printf("adding audio tag to note: %d", noteID)
-	err := ankiconnect.AddNoteTag(noteID, anki.AudioTag)
+	err := g.ankiClient.AddNoteTag(ctx, noteID, g.tags.Audio)
 	if err != nil {
 		return err
 	}
 
-	err = noteaudio.AddAudioToNote(noteID, ankiMediaDir, audioFields, noteaudio.Options{
-		Overwrite: true,
-	})
+	err = noteaudio.AddAudioToNote(ctx, g.ankiClient, noteID, g.mediaStore, g.fieldMap, g.audioOptions)
 	if err != nil {
 		return err
 	}
 
-	err = ankiconnect.AddNoteTag(noteID, anki.AudioGeneratedTag)
+	err = g.ankiClient.AddNoteTag(ctx, noteID, g.tags.AudioGenerated)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("removing audio tag from note: %d", noteID)
-	err = ankiconnect.RemoveNoteTag(noteID, anki.AudioTag)
+	err = g.ankiClient.RemoveNoteTag(ctx, noteID, g.tags.Audio)
 	if err != nil {
 		return err
 	}
@@ -261,6 +468,13 @@ func addAudioToNote(noteID int, ankiMediaDir string) error {
 	return nil
 }
 
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
 type vocabEntry struct {
 	word      string
 	path      string
@@ -314,18 +528,3 @@ func vocabEntriesFromDir(vocabDir string) ([]vocabEntry, error) {
 
 	return words, nil
 }
-
-func extractRetryDelay(errorMessage string) (time.Duration, error) {
-	var retryDelayRegex = regexp.MustCompile(`"retryDelay"\s*:\s*"(\d+)s"`)
-	m := retryDelayRegex.FindStringSubmatch(errorMessage)
-	if m == nil {
-		return time.Second * 0, fmt.Errorf("no regex match")
-	}
-
-	seconds, err := strconv.Atoi(m[1]) // m[1] is the captured digits
-	if err != nil {
-		return time.Second * 0, fmt.Errorf("bad number. match: %s, err: %s", m[1], err)
-	}
-
-	return time.Duration(seconds) * time.Second, nil
-}
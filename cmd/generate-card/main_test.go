@@ -0,0 +1,291 @@
+package main
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/ankiconnect/ankifake"
+	"anki-voice/audio/audiofake"
+	"anki-voice/cardgen"
+	"anki-voice/config"
+	"anki-voice/journal"
+	"anki-voice/noteaudio"
+	"anki-voice/review"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestGenerator returns a generator with the fake card generator, against a fake AnkiConnect with a deck and
+// a note type that has every card field and the audio fields of base_d and s1
+func newTestGenerator(t *testing.T) (*ankifake.Fake, *generator) {
+	t.Helper()
+	audiofake.InstallFFmpeg(t)
+
+	fake := ankifake.New()
+	fake.AddDeck("Deutsch")
+	fake.AddModel("Vokabel", append(cardgen.FieldNames(), "base_a", "s1a")...)
+	server := fake.Start()
+	t.Cleanup(server.Close)
+	client := ankiconnect.NewClient(ankiconnect.Config{URL: server.URL})
+
+	return fake, &generator{
+		ankiClient: client,
+		cards:      &cardgen.Fake{},
+		mediaStore: noteaudio.AnkiConnectStore{Client: client},
+		audioOptions: noteaudio.Options{
+			Overwrite:   true,
+			Synthesizer: &audiofake.Synthesizer{},
+			WorkDir:     t.TempDir(),
+		},
+		deck:     "Deutsch",
+		model:    "Vokabel",
+		fieldMap: map[string]string{"base_d": "base_a", "s1": "s1a"},
+		tags:     config.Tags{Generated: []string{"generated"}, Audio: "audio", AudioGenerated: "audio-generated"},
+		review:   &review.Store{Dir: t.TempDir()},
+	}
+}
+
+// openJournal opens the journal at path for the generator, and closes it at the end of the test
+func openJournal(t *testing.T, g *generator, path string, resume bool) {
+	t.Helper()
+	runJournal, err := journal.Open(path, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { runJournal.Close() })
+	g.journal = runJournal
+}
+
+func TestGenerateNote(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatalf("generateNote() = %v", err)
+	}
+	notes := fake.Notes()
+	if len(notes) != 1 {
+		t.Fatalf("anki has %d notes, want 1", len(notes))
+	}
+	note := notes[0]
+	if note.Deck != "Deutsch" || note.Fields["base_d"] != "schnell" || note.Fields["s1"] != "Das Wort ist schnell." {
+		t.Errorf("note = %+v, want the card of schnell", note)
+	}
+	if !slices.Equal(note.Tags, []string{"generated", "audio-generated"}) {
+		t.Errorf("tags = %v, want the generated tags without the audio tag", note.Tags)
+	}
+	for _, audioField := range []string{"base_a", "s1a"} {
+		if !strings.HasPrefix(note.Fields[audioField], "[sound:") {
+			t.Errorf("%s = %q, want a sound tag", audioField, note.Fields[audioField])
+		}
+	}
+
+	// a word that is already in anki is skipped
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatalf("generateNote() of a duplicate = %v", err)
+	}
+	if notes := fake.Notes(); len(notes) != 1 {
+		t.Errorf("anki has %d notes after the duplicate, want 1", len(notes))
+	}
+}
+
+func TestGenerateNoteResumesAudio(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+	journalPath := filepath.Join(t.TempDir(), "generate-card.journal.jsonl")
+	openJournal(t, g, journalPath, false)
+
+	g.audioOptions.Synthesizer = &audiofake.Synthesizer{Fail: []string{"schnell"}}
+	if err := g.generateNote(ctx, "schnell"); err == nil {
+		t.Fatal("generateNote() succeeded, want the error of the synthesizer")
+	}
+	notes := fake.Notes()
+	if len(notes) != 1 || notes[0].Fields["base_a"] != "" {
+		t.Fatalf("notes = %+v, want the note without audio", notes)
+	}
+	noteID := notes[0].ID
+	g.journal.Close()
+
+	// the resumed run only adds the audio of the note that was added
+	openJournal(t, g, journalPath, true)
+	g.audioOptions.Synthesizer = &audiofake.Synthesizer{}
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatalf("generateNote() of the resumed run = %v", err)
+	}
+	notes = fake.Notes()
+	if len(notes) != 1 || notes[0].ID != noteID || notes[0].Fields["base_a"] == "" {
+		t.Errorf("notes = %+v, want note %d with audio", notes, noteID)
+	}
+}
+
+func TestGenerateNoteResumeSkipsFinishedWord(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+	journalPath := filepath.Join(t.TempDir(), "generate-card.journal.jsonl")
+	openJournal(t, g, journalPath, false)
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatal(err)
+	}
+	g.journal.Close()
+
+	// the note of the finished word is left alone, even after its audio was removed in anki
+	noteID := fake.Notes()[0].ID
+	fake.AddNote(ankifake.Note{ID: noteID, Model: "Vokabel", Deck: "Deutsch", Fields: map[string]string{"base_d": "schnell"}})
+	openJournal(t, g, journalPath, true)
+	synthesizer := &audiofake.Synthesizer{}
+	g.audioOptions.Synthesizer = synthesizer
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatalf("generateNote() of the resumed run = %v", err)
+	}
+	if texts := synthesizer.Texts(); len(texts) != 0 {
+		t.Errorf("synthesized %q, want the finished word skipped", texts)
+	}
+	if note, _ := fake.Note(noteID); note.Fields["base_a"] != "" || len(note.Tags) != 0 {
+		t.Errorf("note = %+v, want it unchanged", note)
+	}
+}
+
+func TestStageCard(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+	g.stage = true
+
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatalf("generateNote() = %v", err)
+	}
+	if notes := fake.Notes(); len(notes) != 0 {
+		t.Errorf("anki has %d notes, want the card only staged", len(notes))
+	}
+
+	entries, err := g.review.List()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("review entries = %v, %v, want the staged card", entries, err)
+	}
+	entry := entries[0]
+	if entry.Word != "schnell" || len(entry.Problems) != 0 {
+		t.Errorf("entry = %+v, want the valid card of schnell", entry)
+	}
+	for field, filename := range entry.Audio {
+		if _, err := os.Stat(g.review.AudioPath(entry.Word, filename)); err != nil {
+			t.Errorf("audio of %s: %v", field, err)
+		}
+	}
+	if len(entry.Audio) != 2 {
+		t.Errorf("entry has the audio %v, want base_d and s1", entry.Audio)
+	}
+}
+
+func TestInvalidCardIsParked(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+	fixtures := t.TempDir()
+	card := `{"base_d": "schnell", "full_d": "schnell", "base_e": "fast", "s1": "Er läuft.", "s1e": "He runs.", "s2": "Ich bin schnell.", "s2e": "I am fast."}`
+	if err := os.WriteFile(filepath.Join(fixtures, "schnell.json"), []byte(card), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g.cards = &cardgen.Fake{Dir: fixtures}
+
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatalf("generateNote() = %v", err)
+	}
+	if notes := fake.Notes(); len(notes) != 0 {
+		t.Errorf("anki has %d notes, want the invalid card parked", len(notes))
+	}
+	entries, _ := g.review.List()
+	if len(entries) != 1 || len(entries[0].Problems) != 1 || !strings.HasPrefix(entries[0].Problems[0], "s1 doesn't use the word") {
+		t.Errorf("review entries = %+v, want the card with the problem of s1", entries)
+	}
+}
+
+func TestStageDotWord(t *testing.T) {
+	ctx := context.Background()
+	_, g := newTestGenerator(t)
+	dataDir := t.TempDir()
+	g.review.Dir = filepath.Join(dataDir, "review")
+	backup := filepath.Join(dataDir, "backups", "undo.jsonl")
+	if err := os.MkdirAll(filepath.Dir(backup), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backup, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g.stage = true
+
+	// -word .. is parked or staged inside the review store, instead of replacing the data directory
+	if err := g.generateNote(ctx, ".."); err != nil {
+		t.Fatalf("generateNote() = %v", err)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("the data directory was changed: %v", err)
+	}
+	if entries, _ := g.review.List(); len(entries) != 1 || entries[0].Word != ".." {
+		t.Errorf("review entries = %+v, want the card of ..", entries)
+	}
+}
+
+func TestReviewAdd(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+	g.stage = true
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the note is added, but its audio fails
+	g.audioOptions.Synthesizer = &audiofake.Synthesizer{Fail: []string{"schnell"}}
+	var out strings.Builder
+	if err := g.runReviewCommand(ctx, strings.NewReader("a\n"), &out); err == nil {
+		t.Fatal("runReviewCommand() succeeded, want the error of the synthesizer")
+	}
+	entries, _ := g.review.List()
+	notes := fake.Notes()
+	if len(notes) != 1 || len(entries) != 1 || entries[0].NoteID != notes[0].ID {
+		t.Fatalf("review entries = %+v, notes = %+v, want the entry to keep the ID of the added note", entries, notes)
+	}
+
+	// the card can't be edited anymore, and adding it again only adds the audio
+	g.audioOptions.Synthesizer = &audiofake.Synthesizer{}
+	out.Reset()
+	if err := g.runReviewCommand(ctx, strings.NewReader("e\na\n"), &out); err != nil {
+		t.Fatalf("runReviewCommand() = %v", err)
+	}
+	if !strings.Contains(out.String(), "already in anki as note") {
+		t.Errorf("output = %q, want edit to be refused", out.String())
+	}
+	notes = fake.Notes()
+	if len(notes) != 1 || notes[0].Fields["base_a"] == "" {
+		t.Errorf("notes = %+v, want the same note with audio", notes)
+	}
+	if entries, _ := g.review.List(); len(entries) != 0 {
+		t.Errorf("review entries = %+v, want the added card removed", entries)
+	}
+}
+
+func TestReviewAddDuplicate(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestGenerator(t)
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatal(err)
+	}
+	g.stage = true
+	if err := g.generateNote(ctx, "schnell"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a duplicate is reported, and the card stays for another choice
+	var out strings.Builder
+	if err := g.runReviewCommand(ctx, strings.NewReader("a\nr\n"), &out); err != nil {
+		t.Fatalf("runReviewCommand() = %v", err)
+	}
+	if !strings.Contains(out.String(), "schnell is already in anki") || !strings.Contains(out.String(), "rejected schnell") {
+		t.Errorf("output = %q, want the duplicate reported and the card rejected", out.String())
+	}
+	if notes := fake.Notes(); len(notes) != 1 {
+		t.Errorf("anki has %d notes, want 1", len(notes))
+	}
+	if entries, _ := g.review.List(); len(entries) != 0 {
+		t.Errorf("review entries = %+v, want the rejected card removed", entries)
+	}
+}
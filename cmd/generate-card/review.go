@@ -0,0 +1,200 @@
+package main
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/cardgen"
+	"anki-voice/review"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runReviewCommand handles "generate-card review": it shows the cards in the review store one by one,
+// and adds, edits or rejects each of them
+func (g *generator) runReviewCommand(ctx context.Context, in io.Reader, out io.Writer) error {
+	entries, err := g.review.List()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(out, "no cards to review in %s\n", g.review.Dir)
+		return nil
+	}
+
+	input := bufio.NewScanner(in)
+	for i, entry := range entries {
+	prompt:
+		for {
+			fmt.Fprintf(out, "\n[%d/%d] ", i+1, len(entries))
+			g.printReviewEntry(out, entry)
+			fmt.Fprint(out, "[a]dd, [e]dit, [p]lay, [r]eject, [s]kip, [q]uit: ")
+			if !input.Scan() {
+				return input.Err()
+			}
+
+			switch strings.ToLower(strings.TrimSpace(input.Text())) {
+			case "a", "add":
+				if entry.NoteID == 0 {
+					noteID, err := g.addNote(ctx, entry.Word, entry.Card)
+					if errors.Is(err, ankiconnect.ErrDuplicateNote) {
+						fmt.Fprintf(out, "%s is already in anki, reject the card or edit it\n", entry.Word)
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					// when the audio fails, the next review only adds the audio, instead of adding the note again
+					entry.NoteID = noteID
+					if err := g.review.Save(entry); err != nil {
+						return err
+					}
+				}
+				if err := g.finishNote(ctx, entry.Word, entry.NoteID); err != nil {
+					return err
+				}
+				if err := g.review.Remove(entry.Word); err != nil {
+					return err
+				}
+				break prompt
+			case "e", "edit":
+				if entry.NoteID != 0 {
+					fmt.Fprintf(out, "the card is already in anki as note %d, choose add to add its audio\n", entry.NoteID)
+					continue
+				}
+				card, err := editCard(entry.Card)
+				if err != nil {
+					fmt.Fprintf(out, "card not changed: %v\n", err)
+					continue
+				}
+				entry.Card = card
+				entry.Problems = nil
+				var invalid *cardgen.ValidationError
+				if errors.As(cardgen.Validate(card), &invalid) {
+					entry.Problems = invalid.Problems
+				}
+				if err := g.synthesizeReviewAudio(ctx, &entry); err != nil {
+					return err
+				}
+				if err := g.review.Save(entry); err != nil {
+					return err
+				}
+			case "p", "play":
+				if err := g.playReviewAudio(entry); err != nil {
+					fmt.Fprintln(out, err)
+				}
+			case "r", "reject":
+				if err := g.review.Remove(entry.Word); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "rejected %s\n", entry.Word)
+				break prompt
+			case "s", "skip":
+				break prompt
+			case "q", "quit":
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// printReviewEntry shows the fields of a card, its problems and where its audio is
+func (g *generator) printReviewEntry(out io.Writer, entry review.Entry) {
+	fmt.Fprintf(out, "%s\n", entry.Word)
+	fields := entry.Card.Fields()
+	for _, field := range cardgen.FieldNames() {
+		if fields[field] == "" {
+			continue
+		}
+		fmt.Fprintf(out, "  %-9s %s\n", field, fields[field])
+		if filename, ok := entry.Audio[field]; ok {
+			fmt.Fprintf(out, "  %-9s %s\n", "", g.review.AudioPath(entry.Word, filename))
+		}
+	}
+	for _, problem := range entry.Problems {
+		fmt.Fprintf(out, "  problem: %s\n", problem)
+	}
+	if entry.NoteID != 0 {
+		fmt.Fprintf(out, "  added as note %d, but its audio is missing\n", entry.NoteID)
+	}
+}
+
+// playReviewAudio plays the audio of a card one field after another with AUDIO_PLAYER, e.g. "mpv",
+// or the first of afplay, mpv or ffplay that is installed
+func (g *generator) playReviewAudio(entry review.Entry) error {
+	player := strings.Fields(os.Getenv("AUDIO_PLAYER"))
+	if len(player) == 0 {
+		for _, candidate := range [][]string{{"afplay"}, {"mpv", "--really-quiet"}, {"ffplay", "-nodisp", "-autoexit", "-loglevel", "quiet"}} {
+			if _, err := exec.LookPath(candidate[0]); err == nil {
+				player = candidate
+				break
+			}
+		}
+	}
+	if len(player) == 0 {
+		return errors.New("no audio player found, set AUDIO_PLAYER")
+	}
+
+	for _, field := range cardgen.FieldNames() {
+		filename, ok := entry.Audio[field]
+		if !ok {
+			continue
+		}
+		cmd := exec.Command(player[0], append(player[1:], g.review.AudioPath(entry.Word, filename))...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %w\n%s", player[0], err, output)
+		}
+	}
+	return nil
+}
+
+// editCard opens the card as JSON in EDITOR (vi by default) and returns the edited card
+func editCard(card cardgen.Card) (cardgen.Card, error) {
+	file, err := os.CreateTemp("", "card-*.json")
+	if err != nil {
+		return card, err
+	}
+	defer os.Remove(file.Name())
+
+	data, err := json.MarshalIndent(card, "", "  ")
+	if err != nil {
+		return card, err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return card, err
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return card, fmt.Errorf("%s: %w", editor[0], err)
+	}
+
+	data, err = os.ReadFile(file.Name())
+	if err != nil {
+		return card, err
+	}
+	// unknown fields are typos, which would silently drop the edit
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var edited cardgen.Card
+	if err := decoder.Decode(&edited); err != nil {
+		return card, err
+	}
+	return edited, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"anki-voice/audio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// runCacheCommand handles "voice cache stats|prune|clear"
+func runCacheCommand(cache *audio.Cache, args []string, out io.Writer) error {
+	if cache == nil {
+		return errors.New("the cache command can't be used with -nocache")
+	}
+	if len(args) != 1 {
+		return errors.New("usage: voice cache stats|prune|clear")
+	}
+
+	switch args[0] {
+	case "stats":
+		stats, err := cache.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "directory: %s\n", cache.Dir)
+		fmt.Fprintf(out, "files:     %d\n", stats.Files)
+		fmt.Fprintf(out, "size:      %.1f MB (limit %d MB)\n", float64(stats.Bytes)/(1<<20), cache.Limit()>>20)
+		if stats.Files > 0 {
+			fmt.Fprintf(out, "oldest:    %s\n", stats.Oldest.Format(time.DateTime))
+			fmt.Fprintf(out, "newest:    %s\n", stats.Newest.Format(time.DateTime))
+		}
+	case "prune":
+		removed, freed, err := cache.Prune(cache.Limit())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "removed %d files, freed %.1f MB\n", removed, float64(freed)/(1<<20))
+	case "clear":
+		removed, freed, err := cache.Prune(0)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "removed %d files, freed %.1f MB\n", removed, float64(freed)/(1<<20))
+	default:
+		return fmt.Errorf("unknown cache command %q, expected stats, prune or clear", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"anki-voice/audio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheCommandWithTheDefaultSize(t *testing.T) {
+	// -cachesize 0 leaves the limit at audio.DefaultCacheMaxBytes
+	cache := &audio.Cache{Dir: t.TempDir()}
+	cached := filepath.Join(cache.Dir, "ab", "ab12.mp3")
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cached, []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := runCacheCommand(cache, []string{"stats"}, &out); err != nil {
+		t.Fatalf("cache stats: %v", err)
+	}
+	if !strings.Contains(out.String(), "(limit 1024 MB)") {
+		t.Errorf("cache stats = %q, want the default limit", out.String())
+	}
+
+	out.Reset()
+	if err := runCacheCommand(cache, []string{"prune"}, &out); err != nil {
+		t.Fatalf("cache prune: %v", err)
+	}
+	if !strings.Contains(out.String(), "removed 0 files") {
+		t.Errorf("cache prune = %q, want nothing removed", out.String())
+	}
+	if _, err := os.Stat(cached); err != nil {
+		t.Errorf("prune removed a file of a cache under its limit: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// configFlag ties a flag to its environment variable and its value in the config file
+type configFlag struct {
+	name  string
+	env   string
+	value string
+}
+
+// applyConfig sets the flags that were neither passed on the command line nor set by their environment variable
+// to their value in the config file. The precedence is: flag, environment variable, config file, default.
+func applyConfig(flags []configFlag) error {
+	passed := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
+	for _, f := range flags {
+		if f.value == "" || passed[f.name] || (f.env != "" && os.Getenv(f.env) != "") {
+			continue
+		}
+		if err := flag.Set(f.name, f.value); err != nil {
+			return fmt.Errorf("config file, %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// formatFloat formats a config value for flag.Set, zero means unset
+func formatFloat(value float64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatDuration formats a config value for flag.Set, zero means unset
+func formatDuration(value time.Duration) string {
+	if value == 0 {
+		return ""
+	}
+	return value.String()
+}
@@ -3,100 +3,645 @@ package main
 import (
 	"anki-voice/anki"
 	"anki-voice/ankiconnect"
+	"anki-voice/audio"
+	"anki-voice/config"
+	"anki-voice/journal"
 	"anki-voice/noteaudio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-)
-
-var (
-	// key: field in German, value: audio field
-	fields = map[string]string{
-		"base_d": "base_a",
-		"s1":     "s1a",
-		"s2":     "s2a",
-		"s3":     "s3a",
-		"s4":     "s4a",
-		"s5":     "s5a",
-		"s6":     "s6a",
-		"s7":     "s7a",
-		"s8":     "s8a",
-		"s9":     "s9a",
-	}
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 func main() {
 	// flags setup
+	configPathFlag := flag.String("config", "", "config file, defaults to ANKI_VOICE_CONFIG, ./anki-voice.yaml or anki-voice/config.yaml in the user config directory")
 	noteIDFlag := flag.Int("note", 0, "noteID to update audio of")
 	limitFlag := flag.Int("limit", 100, "limit the number of cards to update")
-	dryRunFlag := flag.Bool("dryrun", false, "set to true to skip update of the note in anki")
+	dryRunFlag := flag.Bool("dryrun", false, "only print what would be done, without generating audio or changing anything")
+	formatFlag := flag.String("format", "table", "output format of -dryrun: table or json")
+	synthesizeOnlyFlag := flag.Bool("synthesize-only", false, "generate the planned audio into -scratchdir to listen to it, without changing anki")
+	scratchDirFlag := flag.String("scratchdir", "", "directory for -synthesize-only audio, defaults to a new temporary directory")
 	queryFlag := flag.String("query", "", "use an anki query to filter which cards to update")
 	overwriteFlag := flag.Bool("overwrite", false, "set to true to overwrite existing audio")
+	changedFlag := flag.Bool("changed", false, "regenerate audio whose text changed since the audio was generated")
+	retryFlag := flag.String("retry", "", "retry the failed notes of the JSON report of a previous run, see -report")
+	reportFlag := flag.String("report", "", "write a JSON report of the outcome of every note to this file")
+	backupDirFlag := flag.String("backupdir", os.Getenv("AUDIO_BACKUP_DIR"), "directory for the replaced audio and field values of every run, defaults to anki-voice/backups in the user data directory")
+	journalFlag := flag.String("journal", "", "file recording the progress of the run, defaults to voice.journal.jsonl in the user cache directory")
+	resumeFlag := flag.Bool("resume", false, "skip the notes that the journaled run already finished, e.g. after it was interrupted")
+	maxFailuresFlag := flag.Int("maxfailures", 10, "stop the run after more than this many notes failed. 0 never stops")
 	removeTagFlag := flag.String("removetag", "", "remove the specified tag when update of a note succeeds")
+	mediaFlag := flag.String("media", envOrDefault("ANKI_MEDIA_STORE", noteaudio.MediaStoreDir), "how audio is delivered to anki: \"dir\" writes into the local media folder, \"ankiconnect\" uploads through AnkiConnect")
+	mediaDirFlag := flag.String("mediadir", os.Getenv("ANKI_MEDIA_DIR"), "anki's media directory for -media dir, defaults to the profile's collection.media")
+	ankiBaseFlag := flag.String("ankibase", "", "anki's base directory holding the profiles, defaults to ANKI_BASE or the platform's default location")
+	profileFlag := flag.String("profile", os.Getenv("ANKI_PROFILE"), "anki profile whose media directory is used, defaults to the profile anki loaded last")
+	ttsFlag := flag.String("tts", envOrDefault("TTS_BACKEND", audio.BackendPiperHTTP), "tts backend: piper-http, piper or espeak-ng")
+	ttsURLFlag := flag.String("ttsurl", envOrDefault("TTS_URL", audio.DefaultPiperURL), "URL of the piper HTTP server")
+	ttsBinaryFlag := flag.String("ttsbin", os.Getenv("TTS_BINARY"), "path of the piper or espeak-ng binary, defaults to looking it up on the PATH")
+	voiceFlag := flag.String("voice", os.Getenv("TTS_VOICE"), "piper model path or espeak-ng voice, e.g. \"de\"")
+	piperAPIFlag := flag.String("piperapi", envOrDefault("PIPER_API", audio.PiperAPIJSON), "piper HTTP request format: \"json\" for piper's http_server, \"text\" for servers that only accept the raw text")
+	speakerFlag := flag.String("speaker", os.Getenv("TTS_SPEAKER"), "speaker name or id, for multi-speaker voices")
+	lengthScaleFlag := flag.Float64("lengthscale", 0, "speaking speed, e.g. 1.3 for slower audio. 0 uses the voice default")
+	noiseScaleFlag := flag.Float64("noisescale", 0, "piper noise_scale. 0 uses the voice default")
+	noiseWScaleFlag := flag.Float64("noisew", 0, "piper noise_w_scale. 0 uses the voice default")
+	slowFlag := flag.Float64("slow", 0, "also generate a slowed down recording with this length scale, e.g. 1.5")
+	slowSuffixFlag := flag.String("slowsuffix", "", "write the slow recording to the audio field plus this suffix, e.g. \"_slow\" for s1a_slow. empty appends it to the regular audio field")
+	cacheDirFlag := flag.String("cachedir", os.Getenv("AUDIO_CACHE_DIR"), "directory of the audio cache, defaults to the user cache directory")
+	cacheSizeFlag := flag.Int64("cachesize", audio.DefaultCacheMaxBytes>>20, "maximum size of the audio cache in MB")
+	noCacheFlag := flag.Bool("nocache", false, "always synthesize audio, without reading or writing the cache")
+	workDirFlag := flag.String("workdir", os.Getenv("AUDIO_WORK_DIR"), "directory for intermediate audio files, defaults to the system temporary directory")
+	ttsJobsFlag := flag.Int("ttsjobs", audio.DefaultMaxSyntheses, "number of texts synthesized at the same time")
+	ffmpegJobsFlag := flag.Int("ffmpegjobs", runtime.NumCPU(), "number of ffmpeg processes running at the same time")
+	pageSizeFlag := flag.Int("pagesize", 50, "number of notes fetched from anki per request")
+	ankiURLFlag := flag.String("ankiurl", envOrDefault("ANKICONNECT_URL", ankiconnect.DefaultURL), "AnkiConnect URL")
+	ankiKeyFlag := flag.String("ankikey", os.Getenv("ANKICONNECT_API_KEY"), "AnkiConnect API key, if one is configured")
+	ankiTimeoutFlag := flag.Duration("ankitimeout", ankiconnect.DefaultTimeout, "timeout for each AnkiConnect request")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s [flags] cache stats|prune|clear\n       %s [flags] rollback [run-id]\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	configPath, err := config.FindPath(*configPathFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := config.Load(configPath, *profileFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = applyConfig([]configFlag{
+		{name: "ankiurl", env: "ANKICONNECT_URL", value: cfg.AnkiConnect.URL},
+		{name: "ankikey", env: "ANKICONNECT_API_KEY", value: cfg.AnkiConnect.APIKey},
+		{name: "ankitimeout", value: formatDuration(cfg.AnkiConnect.Timeout)},
+		{name: "media", env: "ANKI_MEDIA_STORE", value: cfg.Media.Store},
+		{name: "mediadir", env: "ANKI_MEDIA_DIR", value: cfg.Media.Dir},
+		{name: "ankibase", env: "ANKI_BASE", value: cfg.Media.Base},
+		{name: "profile", env: "ANKI_PROFILE", value: cfg.Media.Profile},
+		{name: "tts", env: "TTS_BACKEND", value: cfg.TTS.Backend},
+		{name: "ttsurl", env: "TTS_URL", value: cfg.TTS.URL},
+		{name: "piperapi", env: "PIPER_API", value: cfg.TTS.API},
+		{name: "ttsbin", env: "TTS_BINARY", value: cfg.TTS.Binary},
+		{name: "voice", env: "TTS_VOICE", value: cfg.TTS.Voice},
+		{name: "speaker", env: "TTS_SPEAKER", value: cfg.TTS.Speaker},
+		{name: "lengthscale", value: formatFloat(cfg.TTS.LengthScale)},
+		{name: "format", value: cfg.Format},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cache *audio.Cache
+	if !*noCacheFlag {
+		cacheDir := *cacheDirFlag
+		if cacheDir == "" {
+			cacheDir, err = audio.DefaultCacheDir()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		cache = &audio.Cache{Dir: cacheDir, MaxBytes: *cacheSizeFlag << 20}
+	}
+
+	if flag.Arg(0) == "cache" {
+		if err := runCacheCommand(cache, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	noteID := *noteIDFlag
-	dryRun := *dryRunFlag
-	overwrite := *overwriteFlag
 	query := *queryFlag
 	tagToRemove := *removeTagFlag
 	limit := *limitFlag
+	voice := audio.VoiceOptions{
+		Speaker:     *speakerFlag,
+		LengthScale: *lengthScaleFlag,
+		NoiseScale:  *noiseScaleFlag,
+		NoiseWScale: *noiseWScaleFlag,
+	}
+	var variants []noteaudio.Variant
+	if *slowFlag != 0 {
+		slowVoice := voice
+		slowVoice.LengthScale = *slowFlag
+		variants = append(variants, noteaudio.Variant{Name: "slow", FieldSuffix: *slowSuffixFlag, Voice: slowVoice})
+	}
+	pageSize := max(*pageSizeFlag, 1)
+
+	ctx := context.Background()
+	client := ankiconnect.NewClient(ankiconnect.Config{
+		URL:     *ankiURLFlag,
+		APIKey:  *ankiKeyFlag,
+		Timeout: *ankiTimeoutFlag,
+	})
 
-	ankiMediaDir, err := anki.MediaDir()
+	mediaStore, err := noteaudio.NewMediaStore(*mediaFlag, client, anki.MediaConfig{
+		Dir:     *mediaDirFlag,
+		BaseDir: *ankiBaseFlag,
+		Profile: *profileFlag,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	backupDir := *backupDirFlag
+	if backupDir == "" {
+		backupDir, err = noteaudio.DefaultUndoDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if flag.Arg(0) == "rollback" {
+		if err := runRollbackCommand(ctx, client, mediaStore, backupDir, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	synthesizer, err := audio.New(audio.Config{
+		Backend: *ttsFlag,
+		URL:     *ttsURLFlag,
+		API:     *piperAPIFlag,
+		Binary:  *ttsBinaryFlag,
+		Voice:   *voiceFlag,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	audioOptions := noteaudio.Options{
+		Overwrite:      *overwriteFlag,
+		OnlyChanged:    *changedFlag,
+		RemoveOldAudio: true,
+		Synthesizer:    audio.LimitSynthesizer(synthesizer, *ttsJobsFlag),
+		Voice:          voice,
+		Variants:       variants,
+		Cache:          cache,
+		Encoders:       audio.NewEncoders(*ffmpegJobsFlag),
+		WorkDir:        *workDirFlag,
+	}
+
+	// check the note types before anything is generated, so a typo in the config doesn't fail every note
+	noteTypes, err := checkNoteTypes(ctx, client, cfg.NoteTypes, audioOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var ids []int
 	switch {
-	case noteID != 0:
-		log.Println("Update one note")
-		err = updateOneNote(noteID, ankiMediaDir, tagToRemove, dryRun, overwrite)
+	case *retryFlag != "":
+		ids, err = failedNoteIDsFromReport(*retryFlag)
 		if err != nil {
 			log.Fatal(err)
 		}
+		log.Printf("Retry %d failed notes of %s", len(ids), *retryFlag)
+	case noteID != 0:
+		log.Println("Update one note")
+		ids = []int{noteID}
 	case query != "":
 		log.Println("Update notes that match query")
-		ids, err := ankiconnect.QueryNotes(query)
+		ids, err = client.QueryNotes(ctx, query)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		for index, id := range ids {
-			err = updateOneNote(id, ankiMediaDir, tagToRemove, dryRun, overwrite)
+		if limit != 0 && len(ids) > limit {
+			ids = ids[:limit]
+		}
+	}
+
+	// a resumed run only skips the notes that a run with the same query and flags finished
+	journalKey := runKey(query, strconv.Itoa(noteID), *retryFlag, strconv.Itoa(limit), tagToRemove,
+		strconv.FormatBool(*overwriteFlag), strconv.FormatBool(*changedFlag))
+	r := &runner{
+		client:       client,
+		mediaStore:   mediaStore,
+		audioOptions: audioOptions,
+		noteTypes:    noteTypes,
+		unmapped:     make(map[string]bool),
+		generatedTag: cfg.Tags.AudioGenerated,
+		tagToRemove:  tagToRemove,
+		report:       newReport(*maxFailuresFlag),
+		dryRun:       *dryRunFlag,
+		journalKey:   journalKey,
+	}
+
+	if *synthesizeOnlyFlag {
+		r.scratchDir = *scratchDirFlag
+		if r.scratchDir == "" {
+			r.scratchDir, err = os.MkdirTemp("", "anki-voice-listen-")
+		} else {
+			err = os.MkdirAll(r.scratchDir, 0o755)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// the journal and the undo log are only needed when anki is changed
+	var runID string
+	if !r.dryRun && r.scratchDir == "" {
+		runID = noteaudio.NewRunID()
+		r.audioOptions.Undo, err = noteaudio.OpenUndo(backupDir, runID)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		journalPath := *journalFlag
+		if journalPath == "" {
+			journalPath, err = journal.DefaultPath("voice")
 			if err != nil {
 				log.Fatal(err)
 			}
+		}
+		r.journal, err = journal.Open(journalPath, *resumeFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	r.stopping = journal.StopOnInterrupt("note")
 
-			if limit != 0 && index+1 >= limit {
-				break
-			}
+	// fetch and update notes page by page, so that a large query doesn't need one request per note
+	var abortErr error
+	for start := 0; start < len(ids) && abortErr == nil; start += pageSize {
+		end := min(start+pageSize, len(ids))
+		abortErr = r.updateNotes(ctx, ids[start:end])
+	}
+	r.report.finish(abortErr)
+	if err := r.journal.Close(); err != nil {
+		log.Print(err)
+	}
+	if err := r.audioOptions.Undo.Close(); err != nil {
+		log.Print(err)
+	}
+	if runID != "" && r.report.Updated > 0 {
+		log.Printf("undo this run with: rollback %s", runID)
+	}
+	if errors.Is(abortErr, errInterrupted) && r.journal != nil {
+		log.Println("continue the run with the same flags and -resume")
+	}
+
+	switch {
+	case r.dryRun:
+		if err := printPlans(os.Stdout, r.plans, *formatFlag); err != nil {
+			log.Fatal(err)
 		}
+	case r.scratchDir != "":
+		log.Printf("generated audio for %d notes in %s", len(r.plans), r.scratchDir)
+	}
+
+	// the summary goes to stderr, so that it doesn't mix with the -dryrun output
+	r.report.printSummary(os.Stderr)
+	if *reportFlag != "" {
+		if err := r.report.writeJSON(*reportFlag); err != nil {
+			log.Fatal(err)
+		}
+		if r.report.Failed > 0 {
+			log.Printf("retry the failed notes with -retry %s", *reportFlag)
+		}
+	}
+	if abortErr != nil || r.report.Failed > 0 {
+		os.Exit(1)
 	}
 }
 
-func updateOneNote(noteID int, ankiMediaDir string, tagToRemove string, dryRun, overwrite bool) error {
-	err := noteaudio.AddAudioToNote(noteID, ankiMediaDir, fields, noteaudio.Options{
-		DryRun:         dryRun,
-		Overwrite:      overwrite,
-		RemoveOldAudio: true,
-	})
+// runner updates the audio of notes page by page. A failed note is recorded in the report
+// and the run continues with the next note.
+type runner struct {
+	client       *ankiconnect.Client
+	mediaStore   noteaudio.MediaStore
+	audioOptions noteaudio.Options
+	noteTypes    map[string]map[string]string // field map of every note type, key: field with text, value: audio field
+	unmapped     map[string]bool              // note types without a field map that were already warned about
+	generatedTag string                       // added to every processed note
+	tagToRemove  string
+	report       *report
+	journal      *journal.Journal
+	journalKey   string          // identifies the run in the journal, see runKey
+	stopping     <-chan struct{} // closed when the run should stop after the current note
+
+	dryRun     bool          // only collect plans
+	scratchDir string        // when set, only synthesize the planned audio into this directory
+	plans      []plannedNote // plans of the notes with changes, collected when dryRun or scratchDir is set
+}
+
+// updateNotes processes one page of notes. It only returns an error when the run should stop.
+func (r *runner) updateNotes(ctx context.Context, noteIDs []int) error {
+	if r.interrupted() {
+		return errInterrupted
+	}
+
+	// skip the notes that the resumed run finished
+	var pending []int
+	for _, noteID := range noteIDs {
+		if r.journal.Done(r.journalItem(noteID)) {
+			r.report.skipped(noteID, "finished by the resumed run")
+			continue
+		}
+		pending = append(pending, noteID)
+	}
+	noteIDs = pending
+	if len(noteIDs) == 0 {
+		return nil
+	}
+
+	notes, err := r.client.GetNotes(ctx, noteIDs, nil)
 	if err != nil {
-		return err
+		for _, noteID := range noteIDs {
+			if abortErr := r.fail(noteID, fmt.Errorf("fetch note: %w", err)); abortErr != nil {
+				return abortErr
+			}
+		}
+		return nil
+	}
+
+	found := make(map[int]bool, len(notes))
+	for _, note := range notes {
+		found[note.NoteID] = true
 	}
+	for _, noteID := range noteIDs {
+		if !found[noteID] {
+			r.report.skipped(noteID, "note no longer exists")
+		}
+	}
+
+	// plan every note first, planning is cheap and doesn't change anything
+	var plans []noteaudio.NotePlan
+	for _, note := range notes {
+		fieldMap, ok := r.noteTypes[note.Model]
+		if !ok {
+			if !r.unmapped[note.Model] {
+				log.Printf("warning: no fields configured for note type %q, skipping its notes", note.Model)
+				r.unmapped[note.Model] = true
+			}
+			r.report.skipped(note.NoteID, fmt.Sprintf("no fields configured for note type %q", note.Model))
+			continue
+		}
 
-	if !dryRun {
-		err = ankiconnect.AddNoteTag(noteID, anki.AudioGeneratedTag)
+		plan, err := noteaudio.PlanNote(note.MapPhrases(fieldMap), fieldMap, r.audioOptions)
+		if errors.Is(err, ankiconnect.ErrFieldNotFound) {
+			err = fmt.Errorf("note doesn't have the expected audio fields: %w", err)
+		}
 		if err != nil {
-			return err
+			if abortErr := r.fail(note.NoteID, err); abortErr != nil {
+				return abortErr
+			}
+			continue
+		}
+		plans = append(plans, plan)
+	}
+
+	// generate the audio of all notes concurrently, while the notes are updated one at a time in order
+	ctx, cancel := context.WithCancel(ctx)
+	prepared := make([]chan preparedNote, len(plans))
+	// remove the audio of notes that were prepared but not committed, e.g. when the run stops
+	defer func() {
+		for _, ch := range prepared {
+			select {
+			case note := <-ch:
+				note.audio.Close()
+			default:
+			}
 		}
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
 
-		if tagToRemove != "" {
-			log.Printf("removing tag in anki: %s\n", tagToRemove)
-			err = ankiconnect.RemoveNoteTag(noteID, tagToRemove)
+	for index, plan := range plans {
+		prepared[index] = make(chan preparedNote, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prepared[index] <- r.prepare(ctx, plan)
+		}()
+	}
+
+	var batch ankiconnect.Batch
+	var batchNoteIDs []int // the note ID of each action in batch
+	var appliedPlans []noteaudio.NotePlan
+	stopped := false
+	// when the run has to stop, the notes that were already applied are still tagged and recorded below
+	var abortErr error
+
+	for index, plan := range plans {
+		// the previous note is finished, so this is the place to stop
+		if r.interrupted() {
+			stopped = true
+			break
+		}
+		note := <-prepared[index]
+		err := note.err
+
+		tags := r.plannedTags()
+		if r.dryRun || r.scratchDir != "" {
 			if err != nil {
-				return err
+				if abortErr = r.fail(plan.NoteID, err); abortErr != nil {
+					break
+				}
+				continue
+			}
+			if len(plan.Fields) > 0 {
+				r.plans = append(r.plans, plannedNote{NotePlan: plan, AddTags: tags.add, RemoveTags: tags.remove})
+			}
+			r.recordPlanned(plan)
+			continue
+		}
+
+		if err == nil {
+			for _, fieldPlan := range plan.Fields {
+				r.journal.Record(journal.Entry{Item: r.journalItem(plan.NoteID), Step: "audio", NoteID: plan.NoteID, Field: fieldPlan.AudioField})
 			}
+			err = noteaudio.CommitPlan(ctx, r.client, r.mediaStore, note.audio, r.audioOptions)
+		}
+		if errors.Is(err, ankiconnect.ErrNoteNotFound) {
+			// the note was deleted after it was fetched
+			log.Printf("note %d no longer exists, skipping it", plan.NoteID)
+			r.report.skipped(plan.NoteID, "note no longer exists")
+			continue
 		}
+		if errors.Is(err, ankiconnect.ErrFieldNotFound) {
+			err = fmt.Errorf("note doesn't have the expected audio fields: %w", err)
+		}
+		if err != nil {
+			if abortErr = r.fail(plan.NoteID, err); abortErr != nil {
+				break
+			}
+			continue
+		}
+
+		for _, tag := range tags.add {
+			batch.AddNoteTag(plan.NoteID, tag)
+			batchNoteIDs = append(batchNoteIDs, plan.NoteID)
+		}
+		for _, tag := range tags.remove {
+			log.Printf("removing tag in anki: %s\n", tag)
+			batch.RemoveNoteTag(plan.NoteID, tag)
+			batchNoteIDs = append(batchNoteIDs, plan.NoteID)
+		}
+		r.journal.Record(journal.Entry{Item: r.journalItem(plan.NoteID), Step: "fields", NoteID: plan.NoteID})
+		appliedPlans = append(appliedPlans, plan)
 	}
 
+	// the outcome of an applied note depends on whether its tags could be updated too
+	tagErrors := make(map[int]error)
+	results, err := r.client.SendBatch(ctx, &batch)
+	if err != nil {
+		for _, noteID := range batchNoteIDs {
+			tagErrors[noteID] = err
+		}
+	}
+	for index, result := range results {
+		noteID := batchNoteIDs[index]
+		if errors.Is(result.Err, ankiconnect.ErrNoteNotFound) {
+			log.Printf("note %d no longer exists, could not update its tags", noteID)
+			continue
+		}
+		if result.Err != nil && tagErrors[noteID] == nil {
+			tagErrors[noteID] = result.Err
+		}
+	}
+
+	for _, plan := range appliedPlans {
+		if tagErr := tagErrors[plan.NoteID]; tagErr != nil {
+			if err := r.fail(plan.NoteID, fmt.Errorf("update tags: %w", tagErr)); err != nil && abortErr == nil {
+				abortErr = err
+			}
+			continue
+		}
+		r.journal.Record(journal.Entry{Item: r.journalItem(plan.NoteID), Step: journal.StepDone, NoteID: plan.NoteID})
+		r.recordPlanned(plan)
+	}
+
+	if abortErr != nil {
+		return abortErr
+	}
+	if stopped {
+		return errInterrupted
+	}
 	return nil
 }
+
+// preparedNote is the outcome of prepare, audio is nil in a dry run or with a scratch directory
+type preparedNote struct {
+	audio *noteaudio.PreparedAudio
+	err   error
+}
+
+// prepare generates the audio of a plan, it runs concurrently for all notes of a page
+func (r *runner) prepare(ctx context.Context, plan noteaudio.NotePlan) preparedNote {
+	switch {
+	case r.scratchDir != "":
+		return preparedNote{err: noteaudio.SynthesizePlan(ctx, plan, r.scratchDir, r.audioOptions)}
+	case r.dryRun:
+		return preparedNote{}
+	default:
+		audio, err := noteaudio.PrepareAudio(ctx, plan, r.audioOptions)
+		return preparedNote{audio: audio, err: err}
+	}
+}
+
+// recordPlanned records a processed note, which is "updated" when its audio changed (or would change in a dry run)
+func (r *runner) recordPlanned(plan noteaudio.NotePlan) {
+	if len(plan.Fields) == 0 {
+		r.report.skipped(plan.NoteID, "audio is up to date")
+		return
+	}
+	r.report.updated(plan.NoteID)
+}
+
+// fail records a failed note. It returns an error when the run should stop: when anki can't be reached,
+// because every following note would fail too, or when too many notes failed.
+func (r *runner) fail(noteID int, err error) error {
+	log.Printf("note %d failed: %v", noteID, err)
+	r.journal.Record(journal.Entry{Item: r.journalItem(noteID), Step: journal.StepFailed, NoteID: noteID, Error: err.Error()})
+	abortErr := r.report.failed(noteID, err)
+	if abortErr == nil && errors.Is(err, ankiconnect.ErrConnectionRefused) {
+		abortErr = err
+	}
+	return abortErr
+}
+
+// checkNoteTypes returns the field maps of the configured note types that exist in anki,
+// after checking that the note types have every configured field
+func checkNoteTypes(ctx context.Context, client *ankiconnect.Client, noteTypes map[string]config.NoteType, options noteaudio.Options) (map[string]map[string]string, error) {
+	models := make([]string, 0, len(noteTypes))
+	for model := range noteTypes {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	fieldMaps := make(map[string]map[string]string)
+	for _, model := range models {
+		fieldNames, err := client.ModelFieldNames(ctx, model)
+		if errors.Is(err, ankiconnect.ErrModelNotFound) {
+			log.Printf("note type %q of the config doesn't exist in anki, ignoring it", model)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := noteaudio.CheckFields(fieldNames, noteTypes[model].Fields, options); err != nil {
+			return nil, fmt.Errorf("note type %q doesn't have the configured fields: %w", model, err)
+		}
+		fieldMaps[model] = noteTypes[model].Fields
+	}
+
+	if len(fieldMaps) == 0 {
+		return nil, errors.New("none of the note types of the config exist in anki")
+	}
+	return fieldMaps, nil
+}
+
+func (r *runner) interrupted() bool {
+	select {
+	case <-r.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// journalItem is the journal item of a note, which includes the run's key, so that a run with another query
+// doesn't skip the notes that a different run finished
+func (r *runner) journalItem(noteID int) string {
+	return r.journalKey + "/" + strconv.Itoa(noteID)
+}
+
+// runKey is a short hash of what selects the notes of a run and what is done to them, e.g. the query and -overwrite
+func runKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+type tagChanges struct {
+	add    []string
+	remove []string
+}
+
+// plannedTags returns the tags changed on every note that was processed
+func (r *runner) plannedTags() tagChanges {
+	changes := tagChanges{add: []string{r.generatedTag}}
+	if r.tagToRemove != "" {
+		changes.remove = []string{r.tagToRemove}
+	}
+	return changes
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/ankiconnect/ankifake"
+	"anki-voice/audio"
+	"anki-voice/audio/audiofake"
+	"anki-voice/config"
+	"anki-voice/journal"
+	"anki-voice/noteaudio"
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testFieldMap = map[string]string{"base_d": "base_a", "s1": "s1a"}
+
+// newTestRunner returns a runner against a fake AnkiConnect with a vocabulary model, which records its
+// progress in the journal at journalPath under the run key "run"
+func newTestRunner(t *testing.T, fake *ankifake.Fake, journalPath string, resume bool, synthesizer audio.Synthesizer) *runner {
+	t.Helper()
+	server := fake.Start()
+	t.Cleanup(server.Close)
+	client := ankiconnect.NewClient(ankiconnect.Config{URL: server.URL})
+
+	journal, err := journal.Open(journalPath, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { journal.Close() })
+
+	return &runner{
+		client:     client,
+		mediaStore: noteaudio.AnkiConnectStore{Client: client},
+		audioOptions: noteaudio.Options{
+			Synthesizer: synthesizer,
+			WorkDir:     t.TempDir(),
+		},
+		noteTypes:    map[string]map[string]string{"Vokabel": testFieldMap},
+		unmapped:     make(map[string]bool),
+		generatedTag: "audio-generated",
+		tagToRemove:  "needs-audio",
+		report:       newReport(0),
+		journal:      journal,
+		journalKey:   "run",
+	}
+}
+
+func newTestFake(t *testing.T) *ankifake.Fake {
+	t.Helper()
+	audiofake.InstallFFmpeg(t)
+
+	fake := ankifake.New()
+	fake.AddModel("Vokabel", "base_d", "base_a", "s1", "s1a")
+	fake.AddModel("Basic", "Front", "Back")
+	return fake
+}
+
+// journalStep returns the last step the journal at path recorded for a note of the run "run"
+func journalStep(t *testing.T, path string, noteID int) string {
+	t.Helper()
+	previous, err := journal.Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer previous.Close()
+
+	entry, _ := previous.Last("run/" + strconv.Itoa(noteID))
+	return entry.Step
+}
+
+// outcomes returns the outcome of every note in the report
+func outcomes(r *report) map[int]string {
+	outcomes := make(map[int]string)
+	for _, note := range r.Notes {
+		outcomes[note.NoteID] = note.Outcome
+	}
+	return outcomes
+}
+
+func TestUpdateNotes(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus", "s1": "Das Haus ist alt."}, Tags: []string{"needs-audio"}})
+	hund := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Hund"}, Tags: []string{"needs-audio"}})
+	basic := fake.AddNote(ankifake.Note{Model: "Basic", Fields: map[string]string{"Front": "Katze"}, Tags: []string{"needs-audio"}})
+	deleted := 9999
+
+	synthesizer := &audiofake.Synthesizer{}
+	journalPath := filepath.Join(t.TempDir(), "voice.journal.jsonl")
+	r := newTestRunner(t, fake, journalPath, false, synthesizer)
+	voice := audio.VoiceOptions{Speaker: "eva_k", LengthScale: 1.3}
+	r.audioOptions.Voice = voice
+	if err := r.updateNotes(context.Background(), []int{haus, hund, basic, deleted}); err != nil {
+		t.Fatalf("updateNotes() = %v", err)
+	}
+	r.journal.Close()
+
+	want := map[int]string{haus: outcomeUpdated, hund: outcomeUpdated, basic: outcomeSkipped, deleted: outcomeSkipped}
+	if got := outcomes(r.report); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+	if texts := synthesizer.Texts(); !slices.Equal(texts, []string{"Das Haus ist alt.", "Haus", "Hund"}) {
+		t.Errorf("synthesized %q", texts)
+	}
+	for _, noteID := range []int{haus, hund} {
+		note, _ := fake.Note(noteID)
+		if note.Fields["base_a"] == "" {
+			t.Errorf("note %d has no audio", noteID)
+		}
+		filename := strings.TrimSuffix(strings.TrimPrefix(note.Fields["base_a"], "[sound:"), "]")
+		if data, _ := fake.Media(filename); string(data) != audiofake.Audio(note.Fields["base_d"], voice) {
+			t.Errorf("media %s = %q, want it spoken with the voice options", filename, data)
+		}
+		if !slices.Equal(note.Tags, []string{"audio-generated"}) {
+			t.Errorf("note %d has the tags %v, want [audio-generated]", noteID, note.Tags)
+		}
+		if step := journalStep(t, journalPath, noteID); step != journal.StepDone {
+			t.Errorf("note %d isn't done in the journal", noteID)
+		}
+	}
+	if note, _ := fake.Note(haus); note.Fields["s1a"] == "" {
+		t.Error("the sentence of the note has no audio")
+	}
+	if note, _ := fake.Note(basic); !slices.Equal(note.Tags, []string{"needs-audio"}) {
+		t.Errorf("the note without configured fields has the tags %v, want them unchanged", note.Tags)
+	}
+}
+
+func TestUpdateNotesTags(t *testing.T) {
+	fake := newTestFake(t)
+	// the audio exists already, so the notes are only tagged
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{
+		"base_d": "Haus",
+		"base_a": "[sound:haus.mp3]",
+	}, Tags: []string{"needs-audio"}})
+	hund := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{
+		"base_d": "Hund",
+		"base_a": "[sound:hund.mp3]",
+	}, Tags: []string{"needs-audio", "tiere"}})
+
+	r := newTestRunner(t, fake, filepath.Join(t.TempDir(), "voice.journal.jsonl"), false, &audiofake.Synthesizer{})
+	if err := r.updateNotes(context.Background(), []int{haus, hund}); err != nil {
+		t.Fatalf("updateNotes() = %v", err)
+	}
+
+	wantOutcomes := map[int]string{haus: outcomeSkipped, hund: outcomeSkipped}
+	if got := outcomes(r.report); !maps.Equal(got, wantOutcomes) {
+		t.Errorf("outcomes = %v, want %v", got, wantOutcomes)
+	}
+	want := map[int][]string{haus: {"audio-generated"}, hund: {"tiere", "audio-generated"}}
+	for noteID, tags := range want {
+		note, _ := fake.Note(noteID)
+		if !slices.Equal(note.Tags, tags) {
+			t.Errorf("note %d has the tags %v, want %v", noteID, note.Tags, tags)
+		}
+	}
+}
+
+func TestUpdateNotesFailure(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}, Tags: []string{"needs-audio"}})
+	hund := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Hund"}, Tags: []string{"needs-audio"}})
+
+	journalPath := filepath.Join(t.TempDir(), "voice.journal.jsonl")
+	r := newTestRunner(t, fake, journalPath, false, &audiofake.Synthesizer{Fail: []string{"Haus"}})
+	if err := r.updateNotes(context.Background(), []int{haus, hund}); err != nil {
+		t.Fatalf("updateNotes() = %v", err)
+	}
+	r.journal.Close()
+
+	// the failed note doesn't stop the run
+	want := map[int]string{haus: outcomeFailed, hund: outcomeUpdated}
+	if got := outcomes(r.report); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+	if !slices.Equal(r.report.FailedNoteIDs, []int{haus}) {
+		t.Errorf("failed notes = %v, want [%d]", r.report.FailedNoteIDs, haus)
+	}
+	if note, _ := fake.Note(haus); note.Fields["base_a"] != "" || !slices.Equal(note.Tags, []string{"needs-audio"}) {
+		t.Errorf("failed note = %+v, want it unchanged", note)
+	}
+	if step := journalStep(t, journalPath, haus); step != journal.StepFailed {
+		t.Errorf("journal step of the failed note = %q, want %q", step, journal.StepFailed)
+	}
+
+	// too many failures stop the run
+	r = newTestRunner(t, fake, journalPath, false, &audiofake.Synthesizer{Fail: []string{"Haus", "Hund"}})
+	r.audioOptions.Overwrite = true
+	r.report = newReport(1)
+	if err := r.updateNotes(context.Background(), []int{haus, hund}); !errors.Is(err, errTooManyFailures) {
+		t.Errorf("updateNotes() = %v, want the run to stop after too many failures", err)
+	}
+}
+
+func TestUpdateNotesAbortKeepsAppliedNotes(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}, Tags: []string{"needs-audio"}})
+	hund := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Hund"}, Tags: []string{"needs-audio"}})
+	katze := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Katze"}, Tags: []string{"needs-audio"}})
+
+	// the second failure stops the run, after the first note of the page was applied
+	journalPath := filepath.Join(t.TempDir(), "voice.journal.jsonl")
+	r := newTestRunner(t, fake, journalPath, false, &audiofake.Synthesizer{Fail: []string{"Hund", "Katze"}})
+	r.report = newReport(1)
+	if err := r.updateNotes(context.Background(), []int{haus, hund, katze}); !errors.Is(err, errTooManyFailures) {
+		t.Fatalf("updateNotes() = %v, want the run to stop after too many failures", err)
+	}
+	r.journal.Close()
+
+	note, _ := fake.Note(haus)
+	if note.Fields["base_a"] == "" || !slices.Equal(note.Tags, []string{"audio-generated"}) {
+		t.Errorf("applied note = %+v, want its audio and tags updated", note)
+	}
+	want := map[int]string{haus: outcomeUpdated, hund: outcomeFailed, katze: outcomeFailed}
+	if got := outcomes(r.report); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+	if step := journalStep(t, journalPath, haus); step != journal.StepDone {
+		t.Errorf("journal step of the applied note = %q, want %q", step, journal.StepDone)
+	}
+	if entries, _ := os.ReadDir(r.audioOptions.WorkDir); len(entries) != 0 {
+		t.Errorf("work directory still has %d entries", len(entries))
+	}
+}
+
+func TestUpdateNotesResume(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+	hund := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Hund"}})
+
+	journalPath := filepath.Join(t.TempDir(), "voice.journal.jsonl")
+	first := newTestRunner(t, fake, journalPath, false, &audiofake.Synthesizer{})
+	if err := first.updateNotes(context.Background(), []int{haus}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the resumed run skips the note the first run finished, and only generates the audio of the other one
+	synthesizer := &audiofake.Synthesizer{}
+	resumed := newTestRunner(t, fake, journalPath, true, synthesizer)
+	if err := resumed.updateNotes(context.Background(), []int{haus, hund}); err != nil {
+		t.Fatal(err)
+	}
+	if got := resumed.report.Notes[0]; got.NoteID != haus || got.Reason != "finished by the resumed run" {
+		t.Errorf("first outcome = %+v, want note %d finished by the resumed run", got, haus)
+	}
+	if texts := synthesizer.Texts(); !slices.Equal(texts, []string{"Hund"}) {
+		t.Errorf("synthesized %q, want only the note that wasn't finished", texts)
+	}
+	resumed.journal.Close()
+
+	// a run with another key doesn't skip it, it finds the audio up to date instead
+	other := newTestRunner(t, fake, journalPath, true, &audiofake.Synthesizer{})
+	other.journalKey = "other"
+	if err := other.updateNotes(context.Background(), []int{haus}); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.report.Notes[0]; got.Reason != "audio is up to date" {
+		t.Errorf("outcome of another run = %+v, want the audio up to date", got)
+	}
+}
+
+func TestUpdateNotesStopsWhenInterrupted(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+
+	r := newTestRunner(t, fake, filepath.Join(t.TempDir(), "voice.journal.jsonl"), false, &audiofake.Synthesizer{})
+	stopping := make(chan struct{})
+	close(stopping)
+	r.stopping = stopping
+	if err := r.updateNotes(context.Background(), []int{haus}); !errors.Is(err, errInterrupted) {
+		t.Errorf("updateNotes() = %v, want %v", err, errInterrupted)
+	}
+	if note, _ := fake.Note(haus); note.Fields["base_a"] != "" {
+		t.Errorf("base_a = %q, want the note unchanged", note.Fields["base_a"])
+	}
+}
+
+func TestUpdateNotesDryRun(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}, Tags: []string{"needs-audio"}})
+	hund := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{
+		"base_d": "Hund",
+		"base_a": "[sound:hund.mp3]",
+	}, Tags: []string{"needs-audio"}})
+
+	synthesizer := &audiofake.Synthesizer{}
+	r := newTestRunner(t, fake, filepath.Join(t.TempDir(), "voice.journal.jsonl"), false, synthesizer)
+	r.dryRun = true
+	if err := r.updateNotes(context.Background(), []int{haus, hund}); err != nil {
+		t.Fatalf("updateNotes() = %v", err)
+	}
+
+	if len(r.plans) != 1 || r.plans[0].NoteID != haus || len(r.plans[0].Fields) != 1 {
+		t.Fatalf("plans = %+v, want the audio of Haus only", r.plans)
+	}
+	if plan := r.plans[0]; !slices.Equal(plan.AddTags, []string{"audio-generated"}) || !slices.Equal(plan.RemoveTags, []string{"needs-audio"}) {
+		t.Errorf("planned tags = +%v -%v", plan.AddTags, plan.RemoveTags)
+	}
+	if texts := synthesizer.Texts(); len(texts) != 0 {
+		t.Errorf("the dry run synthesized %q", texts)
+	}
+	if note, _ := fake.Note(haus); note.Fields["base_a"] != "" || !slices.Equal(note.Tags, []string{"needs-audio"}) {
+		t.Errorf("the dry run changed the note to %q, %v", note.Fields["base_a"], note.Tags)
+	}
+}
+
+func TestUpdateNotesSynthesizeOnly(t *testing.T) {
+	fake := newTestFake(t)
+	haus := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}, Tags: []string{"needs-audio"}})
+
+	r := newTestRunner(t, fake, filepath.Join(t.TempDir(), "voice.journal.jsonl"), false, &audiofake.Synthesizer{})
+	r.scratchDir = t.TempDir()
+	if err := r.updateNotes(context.Background(), []int{haus}); err != nil {
+		t.Fatalf("updateNotes() = %v", err)
+	}
+
+	if len(r.plans) != 1 {
+		t.Fatalf("plans = %+v, want the plan of Haus", r.plans)
+	}
+	filename := r.plans[0].Fields[0].Recordings[0].Filename
+	if data, err := os.ReadFile(filepath.Join(r.scratchDir, filename)); string(data) != audiofake.Audio("Haus", audio.VoiceOptions{}) {
+		t.Errorf("scratch file %s = %q, %v, want the audio of Haus", filename, data, err)
+	}
+	if note, _ := fake.Note(haus); note.Fields["base_a"] != "" || len(fake.MediaNames()) != 0 {
+		t.Errorf("synthesizing only changed the collection: base_a = %q, media %v", note.Fields["base_a"], fake.MediaNames())
+	}
+}
+
+func TestCheckNoteTypes(t *testing.T) {
+	ctx := context.Background()
+	fake := newTestFake(t)
+	server := fake.Start()
+	t.Cleanup(server.Close)
+	client := ankiconnect.NewClient(ankiconnect.Config{URL: server.URL})
+
+	noteTypes := map[string]config.NoteType{
+		"Vokabel": {Fields: testFieldMap},
+		"Cloze":   {Fields: map[string]string{"Text": "Audio"}},
+	}
+	fieldMaps, err := checkNoteTypes(ctx, client, noteTypes, noteaudio.Options{})
+	if err != nil {
+		t.Fatalf("checkNoteTypes() = %v", err)
+	}
+	if len(fieldMaps) != 1 || !maps.Equal(fieldMaps["Vokabel"], testFieldMap) {
+		t.Errorf("checkNoteTypes() = %v, want only the field map of Vokabel", fieldMaps)
+	}
+
+	noteTypes["Basic"] = config.NoteType{Fields: map[string]string{"Front": "FrontAudio"}}
+	if _, err := checkNoteTypes(ctx, client, noteTypes, noteaudio.Options{}); !errors.Is(err, ankiconnect.ErrFieldNotFound) {
+		t.Errorf("checkNoteTypes() with a missing field = %v, want %v", err, ankiconnect.ErrFieldNotFound)
+	}
+
+	delete(noteTypes, "Basic")
+	delete(noteTypes, "Vokabel")
+	if _, err := checkNoteTypes(ctx, client, noteTypes, noteaudio.Options{}); err == nil {
+		t.Error("checkNoteTypes() without any existing note type succeeded")
+	}
+}
+
+func TestRunKey(t *testing.T) {
+	if runKey("tag:audio", "0") == runKey("tag:audio", "1") {
+		t.Error("runKey() is the same for different flags")
+	}
+	if runKey("a", "bc") == runKey("ab", "c") {
+		t.Error("runKey() doesn't separate its parts")
+	}
+	if runKey("tag:audio") != runKey("tag:audio") {
+		t.Error("runKey() isn't stable")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"anki-voice/noteaudio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// plannedNote is the plan of a note shown by -dryrun, including the tag changes
+type plannedNote struct {
+	noteaudio.NotePlan
+	AddTags    []string `json:"addTags,omitempty"`
+	RemoveTags []string `json:"removeTags,omitempty"`
+}
+
+func printPlans(w io.Writer, plans []plannedNote, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if plans == nil {
+			plans = []plannedNote{}
+		}
+		return encoder.Encode(plans)
+	case "table":
+		return printPlanTable(w, plans)
+	default:
+		return fmt.Errorf("unknown format %q, expected table or json", format)
+	}
+}
+
+func printPlanTable(w io.Writer, plans []plannedNote) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NOTE\tNAME\tAUDIO FIELD\tNEW FILES\tDELETE\tTAGS\tTEXT")
+
+	fieldCount := 0
+	for _, plan := range plans {
+		for index, fieldPlan := range plan.Fields {
+			var newFiles []string
+			for _, rec := range fieldPlan.Recordings {
+				newFiles = append(newFiles, rec.Filename)
+			}
+
+			// only show note columns on the first row of each note
+			noteID, name, tags := "", "", ""
+			if index == 0 {
+				noteID = fmt.Sprint(plan.NoteID)
+				name = plan.Name
+				tags = formatTags(plan.AddTags, plan.RemoveTags)
+			}
+
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				noteID, name, fieldPlan.AudioField, strings.Join(newFiles, " "),
+				orDash(strings.Join(fieldPlan.RemoveFiles, " ")), tags, fieldPlan.Text)
+			fieldCount++
+		}
+	}
+
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d notes, %d audio fields would be updated\n", len(plans), fieldCount)
+	return err
+}
+
+func formatTags(add, remove []string) string {
+	var parts []string
+	for _, tag := range add {
+		parts = append(parts, "+"+tag)
+	}
+	for _, tag := range remove {
+		parts = append(parts, "-"+tag)
+	}
+	return orDash(strings.Join(parts, " "))
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	outcomeUpdated = "updated"
+	outcomeSkipped = "skipped"
+	outcomeFailed  = "failed"
+)
+
+var (
+	errTooManyFailures = errors.New("too many failures")
+	errInterrupted     = errors.New("interrupted")
+)
+
+// noteOutcome is the result of processing one note
+type noteOutcome struct {
+	NoteID  int    `json:"noteId"`
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// report collects the outcome of every note in a run. Failed notes don't stop the run
+// until more than maxFailures notes failed.
+type report struct {
+	Started       time.Time     `json:"started"`
+	Finished      time.Time     `json:"finished"`
+	Updated       int           `json:"updated"`
+	Skipped       int           `json:"skipped"`
+	Failed        int           `json:"failed"`
+	Aborted       string        `json:"aborted,omitempty"` // why the run stopped early
+	FailedNoteIDs []int         `json:"failedNoteIds"`
+	Notes         []noteOutcome `json:"notes"`
+
+	maxFailures int // 0 means no limit
+}
+
+func newReport(maxFailures int) *report {
+	return &report{
+		Started:       time.Now(),
+		FailedNoteIDs: []int{},
+		Notes:         []noteOutcome{},
+		maxFailures:   maxFailures,
+	}
+}
+
+func (r *report) updated(noteID int) {
+	r.Notes = append(r.Notes, noteOutcome{NoteID: noteID, Outcome: outcomeUpdated})
+	r.Updated++
+}
+
+func (r *report) skipped(noteID int, reason string) {
+	r.Notes = append(r.Notes, noteOutcome{NoteID: noteID, Outcome: outcomeSkipped, Reason: reason})
+	r.Skipped++
+}
+
+// failed records a failed note, and returns errTooManyFailures once the failure threshold is exceeded
+func (r *report) failed(noteID int, err error) error {
+	r.Notes = append(r.Notes, noteOutcome{NoteID: noteID, Outcome: outcomeFailed, Reason: err.Error()})
+	r.Failed++
+	r.FailedNoteIDs = append(r.FailedNoteIDs, noteID)
+
+	if r.maxFailures > 0 && r.Failed > r.maxFailures {
+		return fmt.Errorf("%w: %d notes failed, the limit is %d", errTooManyFailures, r.Failed, r.maxFailures)
+	}
+	return nil
+}
+
+func (r *report) finish(abortErr error) {
+	r.Finished = time.Now()
+	if abortErr != nil {
+		r.Aborted = abortErr.Error()
+	}
+	sort.Ints(r.FailedNoteIDs)
+}
+
+func (r *report) printSummary(w io.Writer) {
+	fmt.Fprintf(w, "\n%d updated, %d skipped, %d failed in %s\n", r.Updated, r.Skipped, r.Failed, r.Finished.Sub(r.Started).Round(time.Second))
+	if r.Aborted != "" {
+		fmt.Fprintf(w, "run aborted: %s\n", r.Aborted)
+	}
+
+	if r.Failed == 0 {
+		return
+	}
+	fmt.Fprintln(w, "failed notes:")
+	for _, outcome := range r.Notes {
+		if outcome.Outcome == outcomeFailed {
+			fmt.Fprintf(w, "  %d: %s\n", outcome.NoteID, outcome.Reason)
+		}
+	}
+}
+
+func (r *report) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// failedNoteIDsFromReport reads the failed note IDs of a previous run's JSON report, to retry them
+func failedNoteIDsFromReport(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous report
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return nil, fmt.Errorf("read report %s: %w", path, err)
+	}
+	return previous.FailedNoteIDs, nil
+}
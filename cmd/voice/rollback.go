@@ -0,0 +1,32 @@
+package main
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/noteaudio"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// runRollbackCommand handles "voice rollback [run-id]", without a run ID it lists the runs that can be rolled back
+func runRollbackCommand(ctx context.Context, client *ankiconnect.Client, media noteaudio.MediaStore, backupDir string, args []string) error {
+	switch len(args) {
+	case 0:
+		runIDs, err := noteaudio.UndoRuns(backupDir)
+		if err != nil {
+			return err
+		}
+		if len(runIDs) == 0 {
+			fmt.Printf("no runs to roll back in %s\n", backupDir)
+			return nil
+		}
+		for _, runID := range runIDs {
+			fmt.Println(runID)
+		}
+		return nil
+	case 1:
+		return noteaudio.Rollback(ctx, client, media, backupDir, args[0])
+	default:
+		return errors.New("usage: voice rollback [run-id]")
+	}
+}
@@ -0,0 +1,228 @@
+// Package config reads the optional config file shared by the commands. Flags and environment variables
+// override the values of the config file.
+package config
+
+import (
+	"anki-voice/anki"
+	"anki-voice/ankiconnect"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the config file looked up in the working directory
+const FileName = "anki-voice.yaml"
+
+// Config is the content of the config file. Empty values use the commands' defaults.
+type Config struct {
+	AnkiConnect AnkiConnect `yaml:"ankiconnect"`
+	Media       Media       `yaml:"media"`
+	TTS         TTS         `yaml:"tts"`
+	LLM         LLM         `yaml:"llm"`   // generates the cards of generate-card
+	Deck        string      `yaml:"deck"`  // deck of generated notes
+	Model       string      `yaml:"model"` // note type of generated notes
+	Tags        Tags        `yaml:"tags"`
+	Format      string      `yaml:"format"` // output format of voice -dryrun
+	// NoteTypes maps note type names to their fields
+	NoteTypes map[string]NoteType `yaml:"noteTypes"`
+	// Profiles holds sections that override the settings above for one anki profile, see Load
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+type AnkiConnect struct {
+	URL     string        `yaml:"url"`
+	APIKey  string        `yaml:"apiKey"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type Media struct {
+	Store   string `yaml:"store"`   // "dir" or "ankiconnect"
+	Dir     string `yaml:"dir"`     // see anki.MediaConfig
+	Base    string `yaml:"base"`    // see anki.MediaConfig
+	Profile string `yaml:"profile"` // anki profile, also selects the profile section of the config
+}
+
+type TTS struct {
+	Backend     string  `yaml:"backend"`
+	URL         string  `yaml:"url"`
+	API         string  `yaml:"api"`
+	Binary      string  `yaml:"binary"`
+	Voice       string  `yaml:"voice"`
+	Speaker     string  `yaml:"speaker"`
+	LengthScale float64 `yaml:"lengthScale"`
+}
+
+type LLM struct {
+	Provider string `yaml:"provider"` // gemini, openai or fake
+	Model    string `yaml:"model"`
+	URL      string `yaml:"url"` // base URL of an OpenAI-compatible API, e.g. a local llama.cpp or Ollama server
+	APIKey   string `yaml:"apiKey"`
+	Fixtures string `yaml:"fixtures"` // directory with <word>.json cards of the fake provider
+}
+
+type Tags struct {
+	Generated      []string `yaml:"generated"`      // added to the notes generate-card adds
+	Audio          string   `yaml:"audio"`          // marks notes that still need audio
+	AudioGenerated string   `yaml:"audioGenerated"` // added to notes once their audio was generated
+}
+
+// NoteType describes the fields of a note type
+type NoteType struct {
+	// Fields maps the fields with text to the fields that get its audio
+	Fields map[string]string `yaml:"fields"`
+}
+
+// Default returns the built-in settings, which the config file is read on top of
+func Default() Config {
+	return Config{
+		Deck:  ankiconnect.DefaultDeck,
+		Model: ankiconnect.DefaultModel,
+		Tags: Tags{
+			Generated:      []string{ankiconnect.DefaultNoteTag},
+			Audio:          anki.AudioTag,
+			AudioGenerated: anki.AudioGeneratedTag,
+		},
+		NoteTypes: map[string]NoteType{
+			ankiconnect.DefaultModel: {
+				Fields: map[string]string{
+					"base_d": "base_a",
+					"s1":     "s1a",
+					"s2":     "s2a",
+					"s3":     "s3a",
+					"s4":     "s4a",
+					"s5":     "s5a",
+					"s6":     "s6a",
+					"s7":     "s7a",
+					"s8":     "s8a",
+					"s9":     "s9a",
+				},
+			},
+		},
+	}
+}
+
+// FindPath returns the config file to read: path when it's set, otherwise ANKI_VOICE_CONFIG,
+// anki-voice.yaml in the working directory, or config.yaml in the user config directory.
+// It returns "" when there is no config file, and an error when an explicitly set file is missing.
+func FindPath(path string) (string, error) {
+	if path == "" {
+		path = os.Getenv("ANKI_VOICE_CONFIG")
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("config file: %w", err)
+		}
+		return path, nil
+	}
+
+	candidates := []string{FileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "anki-voice", "config.yaml"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+// DataDir returns the directory for data of anki-voice that must not be removed like a cache, e.g. backups:
+// $XDG_DATA_HOME/anki-voice (~/.local/share/anki-voice) on Linux, ~/Library/Application Support/anki-voice
+// on macOS and %APPDATA%\anki-voice on Windows
+func DataDir() (string, error) {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "anki-voice"), nil
+	default:
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dataHome = filepath.Join(homeDir, ".local", "share")
+		}
+		return filepath.Join(dataHome, "anki-voice"), nil
+	}
+}
+
+// Load reads the config file at path on top of Default. An empty path returns Default.
+// The section of profiles for profile is applied last, or the one for media.profile when profile is empty.
+// A profile section overrides single values, and adds to the note types.
+func Load(path, profile string) (Config, error) {
+	config := Default()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, fmt.Errorf("config file: %w", err)
+	}
+	if err != nil {
+		return config, err
+	}
+
+	// note types of the file replace the default ones instead of being merged into them
+	var noteTypes struct {
+		NoteTypes map[string]NoteType `yaml:"noteTypes"`
+	}
+	if err := yaml.Unmarshal(data, &noteTypes); err == nil && noteTypes.NoteTypes != nil {
+		config.NoteTypes = nil
+	}
+
+	if err := decodeStrict(data, &config); err != nil {
+		return config, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	if profile == "" {
+		profile = config.Media.Profile
+	}
+	if section, ok := config.Profiles[profile]; ok && profile != "" {
+		// encoded again, because a yaml.Node can't reject unknown keys itself
+		sectionData, err := yaml.Marshal(&section)
+		if err == nil {
+			err = decodeStrict(sectionData, &config)
+		}
+		if err != nil {
+			return config, fmt.Errorf("config file %s, profile %s: %w", path, profile, err)
+		}
+		config.Media.Profile = profile
+	}
+
+	return config, nil
+}
+
+// decodeStrict decodes YAML and rejects unknown keys, so that typos don't go unnoticed
+func decodeStrict(data []byte, out any) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(out)
+	if errors.Is(err, io.EOF) {
+		// an empty file
+		return nil
+	}
+	return err
+}
+
+// FieldMap returns the text field to audio field mapping of a note type
+func (c Config) FieldMap(model string) (map[string]string, error) {
+	noteType, ok := c.NoteTypes[model]
+	if !ok || len(noteType.Fields) == 0 {
+		return nil, fmt.Errorf("no fields configured for note type %q", model)
+	}
+	return noteType.Fields, nil
+}
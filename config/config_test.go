@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "anki-voice.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := writeConfig(t, `
+deck: Deutsch
+tts:
+  voice: de_DE-thorsten-medium
+noteTypes:
+  Vokabel:
+    fields:
+      base_d: base_a
+profiles:
+  Spanish:
+    deck: Spanish
+    tts:
+      voice: es_ES-davefx-medium
+    noteTypes:
+      Palabra:
+        fields:
+          base_s: base_a
+`)
+
+	config, err := Load(path, "Spanish")
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if config.Deck != "Spanish" || config.TTS.Voice != "es_ES-davefx-medium" || config.Media.Profile != "Spanish" {
+		t.Errorf("Load() = deck %q, voice %q, profile %q, want the settings of the profile", config.Deck, config.TTS.Voice, config.Media.Profile)
+	}
+	if _, err := config.FieldMap("Vokabel"); err != nil {
+		t.Errorf("the note types of the file are gone: %v", err)
+	}
+	if _, err := config.FieldMap("Palabra"); err != nil {
+		t.Errorf("the note types of the profile are missing: %v", err)
+	}
+
+	config, err = Load(path, "")
+	if err != nil || config.Deck != "Deutsch" {
+		t.Errorf("Load() without a profile = deck %q, %v, want Deutsch", config.Deck, err)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name, content, profile string
+	}{
+		{"top level", "dek: Deutsch\n", ""},
+		{"profile", "profiles:\n  Spanish:\n    noteTypes:\n      Palabra:\n        feild_map:\n          base_s: base_a\n", "Spanish"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, test.content), test.profile)
+			if err == nil || !strings.Contains(err.Error(), "not found in type") {
+				t.Errorf("Load() = %v, want an error about the unknown key", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package journal
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// StopOnInterrupt returns a channel that is closed on the first SIGINT or SIGTERM, so that a run can finish
+// the current item and stop, to be resumed later. A second interrupt quits right away.
+func StopOnInterrupt(current string) <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	stopping := make(chan struct{})
+	go func() {
+		<-signals
+		// restore the default behavior, so that the next interrupt kills the process
+		signal.Stop(signals)
+		log.Printf("interrupted, finishing the current %s. interrupt again to quit right away", current)
+		close(stopping)
+	}()
+
+	return stopping
+}
@@ -0,0 +1,151 @@
+// Package journal records the progress of a run in a JSON lines file, so that an interrupted run can be resumed
+// without redoing the work that was already finished.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	StepDone   = "done"   // the item is finished, a resumed run skips it
+	StepFailed = "failed" // the item failed, a resumed run tries it again
+)
+
+// Entry is one line of the journal, recorded after a step of an item completed
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Item   string    `json:"item"` // what is processed, e.g. a note ID or a word
+	Step   string    `json:"step"`
+	NoteID int       `json:"noteId,omitempty"`
+	Field  string    `json:"field,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Journal appends entries to a file. A nil journal is valid and records nothing.
+type Journal struct {
+	mu       sync.Mutex
+	file     *os.File
+	previous map[string]Entry // the last entry of every item, from the run that is resumed
+	err      error            // the first failed write, returned by Close
+}
+
+// DefaultPath returns the journal path of a command in the user cache directory
+func DefaultPath(command string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "anki-voice", command+".journal.jsonl"), nil
+}
+
+// Open opens the journal at path. When resume is set, the entries of the previous run are kept and can be
+// looked up with Last. Otherwise the journal starts empty.
+func Open(path string, resume bool) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{previous: make(map[string]Entry)}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		if err := j.load(path); err != nil {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+
+	return j, nil
+}
+
+func (j *Journal) load(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// the last line may be cut off when the previous run was killed while writing it
+			continue
+		}
+		j.previous[entry.Item] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read journal %s: %w", path, err)
+	}
+	return nil
+}
+
+// Last returns the last entry the resumed run recorded for item
+func (j *Journal) Last(item string) (Entry, bool) {
+	if j == nil {
+		return Entry{}, false
+	}
+	entry, ok := j.previous[item]
+	return entry, ok
+}
+
+// Done reports whether the resumed run finished item
+func (j *Journal) Done(item string) bool {
+	entry, ok := j.Last(item)
+	return ok && entry.Step == StepDone
+}
+
+// Record appends an entry and syncs it to disk, so it survives a crash right after
+func (j *Journal) Record(entry Entry) {
+	if j == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = j.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil && j.err == nil {
+		j.err = fmt.Errorf("write journal: %w", err)
+	}
+}
+
+// Close closes the journal file, and returns the first error that happened while recording
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Close(); err != nil && j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
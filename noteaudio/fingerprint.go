@@ -0,0 +1,53 @@
+package noteaudio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fingerprintRegex matches the fingerprint in generated filenames like "123-s1.3f2a9c1b.mp3"
+var fingerprintRegex = regexp.MustCompile(`\.([0-9a-f]{8})\.mp3$`)
+
+// textFingerprint is a short hash of the text an audio file was generated from.
+// It is part of the filename, so a changed text can be detected from the audio field alone.
+func textFingerprint(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:4])
+}
+
+// needsAudio decides whether audio has to be generated for an audio field with the current value oldValue
+func needsAudio(noteID int, field, text, oldValue string, options Options) bool {
+	switch {
+	case oldValue == "", options.Overwrite:
+		return true
+	case options.OnlyChanged:
+		return textChanged(noteID, field, text, oldValue)
+	default:
+		return false
+	}
+}
+
+// textChanged reports whether any audio file this tool generated for the field was generated from a different text.
+// Files without a fingerprint were generated before fingerprints existed, so they count as changed.
+// Files with other names, e.g. imported human recordings, are never considered changed.
+func textChanged(noteID int, field, text, audioValue string) bool {
+	prefix := safeFilename(fmt.Sprintf("%d-%s", noteID, field))
+	current := textFingerprint(text)
+
+	for _, filename := range soundFilenames(audioValue) {
+		// the prefix is followed by the fingerprint, a variant name or the extension
+		if !strings.HasPrefix(filename, prefix+".") && !strings.HasPrefix(filename, prefix+"-") {
+			continue
+		}
+
+		matches := fingerprintRegex.FindStringSubmatch(filename)
+		if matches == nil || matches[1] != current {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,153 @@
+package noteaudio
+
+import (
+	"anki-voice/anki"
+	"anki-voice/ankiconnect"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	MediaStoreDir         = "dir"
+	MediaStoreAnkiConnect = "ankiconnect"
+)
+
+// MediaStore delivers generated audio files into anki's media collection
+type MediaStore interface {
+	// Store moves the local file at path into the media collection with the given filename
+	Store(ctx context.Context, path, filename string) error
+	// Remove deletes a file from the media collection
+	Remove(ctx context.Context, filename string) error
+	// Retrieve copies a file of the media collection to the local path.
+	// The error matches fs.ErrNotExist when there is no such file.
+	Retrieve(ctx context.Context, filename, path string) error
+}
+
+// NewMediaStore returns the MediaStore for kind, which is one of MediaStoreDir or MediaStoreAnkiConnect.
+// dirConfig selects the media directory of MediaStoreDir.
+func NewMediaStore(kind string, client *ankiconnect.Client, dirConfig anki.MediaConfig) (MediaStore, error) {
+	switch kind {
+	case MediaStoreDir:
+		dir, err := anki.FindMediaDir(dirConfig)
+		if err != nil {
+			return nil, err
+		}
+		return DirStore{Dir: dir}, nil
+	case MediaStoreAnkiConnect:
+		return AnkiConnectStore{Client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown media store %q, expected %q or %q", kind, MediaStoreDir, MediaStoreAnkiConnect)
+	}
+}
+
+// DirStore writes directly into the media directory. Only works when anki runs on the same machine.
+type DirStore struct {
+	Dir string
+}
+
+func (s DirStore) Store(ctx context.Context, path, filename string) error {
+	target := filepath.Join(s.Dir, filename)
+
+	err := os.Rename(path, target)
+	if errors.Is(err, syscall.EXDEV) {
+		// rename doesn't work across filesystems, fall back to copying
+		return moveByCopy(path, target)
+	}
+
+	return err
+}
+
+func (s DirStore) Remove(ctx context.Context, filename string) error {
+	return os.Remove(filepath.Join(s.Dir, filename))
+}
+
+func (s DirStore) Retrieve(ctx context.Context, filename, path string) error {
+	data, err := os.ReadFile(filepath.Join(s.Dir, filename))
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// AnkiConnectStore uploads files through AnkiConnect, so anki may run on a different machine
+type AnkiConnectStore struct {
+	Client *ankiconnect.Client
+}
+
+func (s AnkiConnectStore) Store(ctx context.Context, path, filename string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	storedName, err := s.Client.StoreMediaFile(ctx, filename, data)
+	if err != nil {
+		return err
+	}
+	if storedName != filename {
+		// the sound tag would point to a file that doesn't exist, and the stored file would be orphaned
+		if err := s.Client.DeleteMediaFiles(ctx, storedName); err != nil {
+			log.Printf("failed to remove %s: %v", storedName, err)
+		}
+		return fmt.Errorf("anki stored %s as %s", filename, storedName)
+	}
+
+	return os.Remove(path)
+}
+
+func (s AnkiConnectStore) Remove(ctx context.Context, filename string) error {
+	return s.Client.DeleteMediaFiles(ctx, filename)
+}
+
+func (s AnkiConnectStore) Retrieve(ctx context.Context, filename, path string) error {
+	data, err := s.Client.RetrieveMediaFile(ctx, filename)
+	if errors.Is(err, ankiconnect.ErrMediaNotFound) {
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// moveByCopy copies source next to target and renames it into place, so anki never sees a partial file
+func moveByCopy(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(target), ".anki-voice-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Chmod(0o644); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(out.Name(), target); err != nil {
+		return err
+	}
+
+	return os.Remove(source)
+}
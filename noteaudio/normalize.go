@@ -0,0 +1,98 @@
+package noteaudio
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// {{c1::answer}} or {{c1::answer::hint}}
+	clozeRegex = regexp.MustCompile(`\{\{c\d+::(.*?)(?:::.*?)?\}\}`)
+	// anki's furigana syntax, e.g. "漢字[かんじ]", where the reading is only kana
+	furiganaRegex = regexp.MustCompile(`\[[\p{Hiragana}\p{Katakana}ー]+\]`)
+	spaceRegex    = regexp.MustCompile(`\s+`)
+)
+
+// elements that separate lines, so they are read with a pause
+var breakElements = map[atom.Atom]bool{
+	atom.Br: true, atom.Div: true, atom.P: true, atom.Li: true, atom.Tr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// elements whose content is never read out loud. rt and rp hold the reading of ruby text.
+var skippedElements = map[atom.Atom]bool{
+	atom.Rt: true, atom.Rp: true, atom.Script: true, atom.Style: true,
+}
+
+// normalizeFieldText turns the HTML of an anki field into the plain text that should be spoken:
+// markup is dropped, entities decoded, sound tags removed, cloze deletions resolved to their answer,
+// readings of furigana left out, and line breaks turned into pauses.
+func normalizeFieldText(fieldHTML string) string {
+	text := soundRegex.ReplaceAllString(fieldHTML, " ")
+	text = clozeRegex.ReplaceAllString(text, "$1")
+
+	var builder strings.Builder
+	skipDepth := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(text))
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// io.EOF, the tokenizer doesn't fail on malformed HTML
+			break
+		}
+
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.TextToken:
+			if skipDepth == 0 {
+				// the readings are removed before a pause is written, so that there is never a pause after nothing
+				builder.WriteString(furiganaRegex.ReplaceAllString(token.Data, ""))
+			}
+		case html.StartTagToken:
+			if skippedElements[token.DataAtom] {
+				skipDepth++
+			}
+			if breakElements[token.DataAtom] {
+				writePause(&builder)
+			}
+		case html.EndTagToken:
+			if skippedElements[token.DataAtom] && skipDepth > 0 {
+				skipDepth--
+			}
+			if breakElements[token.DataAtom] {
+				writePause(&builder)
+			}
+		case html.SelfClosingTagToken:
+			if breakElements[token.DataAtom] {
+				writePause(&builder)
+			}
+		}
+	}
+
+	// decoded &nbsp; is a non breaking space, which \s doesn't match
+	text = strings.ReplaceAll(builder.String(), "\u00a0", " ")
+	text = spaceRegex.ReplaceAllString(text, " ")
+	return strings.TrimSpace(text)
+}
+
+// writePause ends the current line with a period, unless it already ends with punctuation,
+// so the tts voice pauses there instead of running the lines together
+func writePause(builder *strings.Builder) {
+	current := strings.TrimRightFunc(builder.String(), unicode.IsSpace)
+	if current == "" {
+		return
+	}
+
+	last := []rune(current)[len([]rune(current))-1]
+	if !unicode.IsPunct(last) {
+		builder.Reset()
+		builder.WriteString(current)
+		builder.WriteString(".")
+	}
+	builder.WriteString(" ")
+}
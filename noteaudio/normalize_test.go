@@ -0,0 +1,42 @@
+package noteaudio
+
+import "testing"
+
+func TestNormalizeFieldText(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{"plain text", "Das Haus ist alt.", "Das Haus ist alt."},
+		{"markup", "Das <b>Haus</b> ist <i>alt</i>.", "Das Haus ist alt."},
+		{"line break", "Das Haus<br>ist alt", "Das Haus. ist alt"},
+		{"line break after punctuation", "Wo ist es?<br/>Dort!", "Wo ist es? Dort!"},
+		{"divs", "<div>erste Zeile</div><div>zweite Zeile</div>", "erste Zeile. zweite Zeile."},
+		{"empty lines", "<div><br></div><div>Hallo</div>", "Hallo."},
+		{"list", "<ul><li>eins</li><li>zwei</li></ul>", "eins. zwei."},
+		{"entities", "Tom &amp; Jerry&nbsp;sind &quot;Freunde&quot;", `Tom & Jerry sind "Freunde"`},
+		{"only nbsp", "&nbsp;<br>Hallo", "Hallo"},
+		{"spaces", "  viel \n\t Platz  ", "viel Platz"},
+		{"cloze", "Das {{c1::Haus}} ist {{c2::alt::Adjektiv}}.", "Das Haus ist alt."},
+		{"cloze with markup", "{{c1::<b>Haus</b>}}", "Haus"},
+		{"sound tag", "Haus[sound:haus.mp3]", "Haus"},
+		{"sound tag between words", "das [sound:haus.mp3] Haus", "das Haus"},
+		{"only a sound tag", "[sound:haus.mp3]", ""},
+		{"ruby", "<ruby>漢字<rt>かんじ</rt></ruby>を読む", "漢字を読む"},
+		{"ruby with rp", "<ruby>漢<rp>(</rp><rt>かん</rt><rp>)</rp></ruby>", "漢"},
+		{"furigana", "漢字[かんじ]を 読[よ]む", "漢字を 読む"},
+		{"line of only furigana", "[かんじ]<br>漢字", "漢字"},
+		{"brackets that aren't furigana", "Haus [n]", "Haus [n]"},
+		{"script and style", "<style>b { color: red }</style>Haus<script>alert(1)</script>", "Haus"},
+		{"leading ellipsis", "...und dann", "...und dann"},
+		{"leading ellipsis after a break", "<div>...und dann</div>", "...und dann."},
+		{"malformed html", "Das <b>Haus ist <i alt", "Das Haus ist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeFieldText(test.html); got != test.want {
+				t.Errorf("normalizeFieldText(%q) = %q, want %q", test.html, got, test.want)
+			}
+		})
+	}
+}
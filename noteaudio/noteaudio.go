@@ -3,97 +3,214 @@ package noteaudio
 import (
 	"anki-voice/ankiconnect"
 	"anki-voice/audio"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strings"
+	"sync"
 )
 
 type Options struct {
-	DryRun         bool
 	Overwrite      bool
+	OnlyChanged    bool // regenerate audio whose text changed since it was generated, see needsAudio
 	RemoveOldAudio bool
+	Synthesizer    audio.Synthesizer // defaults to the piper HTTP server
+	Voice          audio.VoiceOptions
+	Variants       []Variant    // additional recordings of every phrase
+	Cache          *audio.Cache // reuses previously generated audio when set
+	// Encoders limits the ffmpeg processes of concurrent PrepareAudio calls, see audio.NewEncoders. nil doesn't limit them.
+	Encoders *audio.Encoders
+	// WorkDir holds intermediate audio files before they are moved into the media collection.
+	// Each call uses its own temporary directory inside it. Defaults to the system temporary directory.
+	WorkDir string
+	// Undo keeps the audio and field values that are replaced, so that they can be restored with Rollback
+	Undo *Undo
 }
 
-var soundRegex = regexp.MustCompile(`^\[sound:([^\]]+)\]$`)
+// Variant is an additional recording of every phrase with different synthesis settings,
+// e.g. a slowed down version for listening practice.
+type Variant struct {
+	Name string // used in the filename, e.g. "slow"
+	// FieldSuffix selects where the recording goes. When empty, its sound tag is appended to the regular audio field.
+	// Otherwise it is written to its own field, named after the audio field plus the suffix, e.g. "s1a" + "_slow".
+	FieldSuffix string
+	Voice       audio.VoiceOptions
+}
+
+var (
+	soundRegex          = regexp.MustCompile(`\[sound:([^\]]+)\]`)
+	unsafeFilenameRegex = regexp.MustCompile(`[^\p{L}\p{N}_.-]`)
+)
 
-func AddAudioToNote(noteID int, ankiMediaDir string, fieldMap map[string]string, options Options) error {
-	note, err := ankiconnect.GetNote(noteID, fieldMap)
+func AddAudioToNote(ctx context.Context, client *ankiconnect.Client, noteID int, media MediaStore, fieldMap map[string]string, options Options) error {
+	note, err := client.GetNote(ctx, noteID, fieldMap)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("--- note: %s ---", note.Phrases["base_d"].Value)
+	return UpdateNoteAudio(ctx, client, note, media, fieldMap, options)
+}
 
-	for field, phrase := range note.Phrases {
-		// ignore non breaking spaces
-		text := sanitizePhraseText(phrase.Value)
-		if text == "" {
-			continue
-		}
+// UpdateNoteAudio generates audio for a note that has already been fetched, e.g. with ankiconnect.GetNotes.
+// All audio fields of the note are updated in a single request.
+func UpdateNoteAudio(ctx context.Context, client *ankiconnect.Client, note ankiconnect.Note, media MediaStore, fieldMap map[string]string, options Options) error {
+	plan, err := PlanNote(note, fieldMap, options)
+	if err != nil {
+		return err
+	}
 
-		if phrase.Audio != "" && !options.Overwrite {
-			// audio has already been generated
-			continue
-		}
+	return ApplyPlan(ctx, client, media, plan, options)
+}
 
-		log.Printf("generating audio for: '%s'\n", text)
-		outputPath := fmt.Sprintf("./output/%s.mp3", text)
-		if err := audio.GenerateMP3(text, outputPath); err != nil {
-			return err
-		}
+// ApplyPlan generates the planned audio, stores it in the media collection, updates the note's audio fields
+// and removes audio files that are no longer used
+func ApplyPlan(ctx context.Context, client *ankiconnect.Client, media MediaStore, plan NotePlan, options Options) error {
+	prepared, err := PrepareAudio(ctx, plan, options)
+	if err != nil {
+		return err
+	}
+	defer prepared.Close()
 
-		filename := fmt.Sprintf("%d-%s.mp3", note.NoteID, field)
-		if err := os.Rename(outputPath, filepath.Join(ankiMediaDir, filename)); err != nil {
-			return err
-		}
+	return CommitPlan(ctx, client, media, prepared, options)
+}
 
-		newAudioFieldValue := fmt.Sprintf("[sound:%s]", filename)
-		if options.DryRun {
-			log.Printf("skipping note update. audio: %s", newAudioFieldValue)
-			continue
-		}
+// PreparedAudio is the audio of a plan generated by PrepareAudio, waiting in a work directory for CommitPlan
+type PreparedAudio struct {
+	Plan NotePlan
+	dir  string
+}
+
+// Close removes the work directory with the audio that wasn't stored, e.g. of a note that is never committed.
+// It may be called more than once.
+func (p *PreparedAudio) Close() error {
+	if p == nil || p.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(p.dir)
+	p.dir = ""
+	return err
+}
 
-		log.Printf("updating field in anki: %s\n", text)
-		if err := ankiconnect.UpdateNoteField(note.NoteID, fieldMap[field], newAudioFieldValue); err != nil {
+// PrepareAudio generates the planned audio into a work directory, without changing anki.
+// It can run concurrently for several notes, while CommitPlan stores the audio and updates the notes one at a time.
+// It doesn't log, so that the log output doesn't depend on the order in which concurrent calls finish.
+// The returned audio must be committed or closed.
+func PrepareAudio(ctx context.Context, plan NotePlan, options Options) (*PreparedAudio, error) {
+	if len(plan.Fields) == 0 {
+		return &PreparedAudio{Plan: plan}, nil
+	}
+
+	// a separate directory per call, so concurrent runs never write to the same file
+	workDir, err := os.MkdirTemp(options.WorkDir, "anki-voice-")
+	if err != nil {
+		return nil, fmt.Errorf("create work directory: %w", err)
+	}
+
+	if err := generateRecordings(ctx, plan, workDir, options); err != nil {
+		os.RemoveAll(workDir)
+		return nil, err
+	}
+
+	return &PreparedAudio{Plan: plan, dir: workDir}, nil
+}
+
+// CommitPlan stores the audio generated by PrepareAudio in the media collection, updates the note's audio fields
+// and removes audio files that are no longer used. The prepared audio is closed afterwards.
+func CommitPlan(ctx context.Context, client *ankiconnect.Client, media MediaStore, prepared *PreparedAudio, options Options) error {
+	defer prepared.Close()
+
+	plan := prepared.Plan
+	log.Printf("--- note: %s ---", plan.Name)
+	if len(plan.Fields) == 0 {
+		return nil
+	}
+
+	updatedFields := make(map[string]string)
+	oldFields := make(map[string]string)
+	for _, fieldPlan := range plan.Fields {
+		if err := options.Undo.backupReplaced(ctx, media, fieldPlan); err != nil {
 			return err
 		}
 
-		if options.RemoveOldAudio {
-			if err := removeOldAudioFile(phrase.Audio, newAudioFieldValue, ankiMediaDir); err != nil {
+		for _, rec := range fieldPlan.Recordings {
+			if err := media.Store(ctx, filepath.Join(prepared.dir, rec.Filename), rec.Filename); err != nil {
 				return err
 			}
 		}
+
+		log.Printf("generated audio for: '%s'\n", fieldPlan.Text)
+		updatedFields[fieldPlan.AudioField] = fieldPlan.NewValue
+		oldFields[fieldPlan.AudioField] = fieldPlan.OldValue
+	}
+
+	if err := options.Undo.record(undoEntry{NoteID: plan.NoteID, Fields: oldFields}); err != nil {
+		return err
+	}
+
+	log.Printf("updating %d audio fields in anki\n", len(updatedFields))
+	if err := client.UpdateNoteFields(ctx, plan.NoteID, updatedFields); err != nil {
+		return err
+	}
+
+	for _, fieldPlan := range plan.Fields {
+		for _, filename := range fieldPlan.RemoveFiles {
+			// keep the old audio when it can't be backed up, it may be a recording that can't be generated again
+			if err := options.Undo.backupFile(ctx, media, filename); err != nil {
+				log.Printf("keeping old audio %s: %v", filename, err)
+				continue
+			}
+			if err := media.Remove(ctx, filename); err != nil {
+				log.Printf("failed to remove old audio %s: %v", filename, err)
+			}
+		}
 	}
 
 	return nil
 }
 
-func sanitizePhraseText(text string) string {
-	// ignore non breaking spaces
-	trimmed := strings.ReplaceAll(text, "&nbsp;", "")
-	return strings.TrimSpace(trimmed)
+// SynthesizePlan only generates the planned audio into dir, e.g. to listen to it before updating anki.
+// Like PrepareAudio, it can run concurrently for several notes.
+func SynthesizePlan(ctx context.Context, plan NotePlan, dir string, options Options) error {
+	return generateRecordings(ctx, plan, dir, options)
 }
 
-func removeOldAudioFile(oldAudioValue, newAudioValue, ankiMediaDir string) error {
-	if oldAudioValue == "" || oldAudioValue == newAudioValue {
-		return nil
+// generateRecordings generates all recordings of a plan into dir at the same time. How many of them are
+// actually synthesized and encoded at once is limited by the synthesizer (see audio.LimitSynthesizer)
+// and by Options.Encoders.
+func generateRecordings(ctx context.Context, plan NotePlan, dir string, options Options) error {
+	synthesizer := options.Synthesizer
+	if synthesizer == nil {
+		synthesizer = &audio.PiperHTTP{}
 	}
 
-	trimmed := strings.TrimSpace(oldAudioValue)
-	matches := soundRegex.FindStringSubmatch(trimmed)
-	if len(matches) != 2 {
-		log.Printf("unexpected audio format: %s", oldAudioValue)
-		return nil
+	count := 0
+	for _, fieldPlan := range plan.Fields {
+		count += len(fieldPlan.Recordings)
 	}
 
-	oldFilename := matches[1]
-	oldFilePath := filepath.Join(ankiMediaDir, oldFilename)
-	if err := os.Remove(oldFilePath); err != nil {
-		log.Printf("failed to remove old audio %s: %v", oldFilePath, err)
+	errs := make([]error, 0, count)
+	var wg sync.WaitGroup
+	for _, fieldPlan := range plan.Fields {
+		for _, rec := range fieldPlan.Recordings {
+			errs = append(errs, nil)
+			err := &errs[len(errs)-1]
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				*err = options.Cache.GenerateMP3(ctx, synthesizer, options.Encoders, fieldPlan.Text, filepath.Join(dir, rec.Filename), rec.Voice)
+			}()
+		}
 	}
+	wg.Wait()
 
+	// the first failed recording in plan order, so the error doesn't depend on timing
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
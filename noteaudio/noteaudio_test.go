@@ -0,0 +1,373 @@
+package noteaudio
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/ankiconnect/ankifake"
+	"anki-voice/audio"
+	"anki-voice/audio/audiofake"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var (
+	testFieldMap = map[string]string{"base_d": "base_a", "s1": "s1a"}
+	testVoice    = audio.VoiceOptions{LengthScale: 1}
+)
+
+// newTestCollection starts a fake AnkiConnect with a vocabulary model, and puts the fake ffmpeg on the PATH
+func newTestCollection(t *testing.T, fields ...string) (*ankifake.Fake, *ankiconnect.Client, MediaStore) {
+	t.Helper()
+	audiofake.InstallFFmpeg(t)
+
+	fake := ankifake.New()
+	fake.AddModel("Vokabel", append([]string{"base_d", "base_a", "s1", "s1a"}, fields...)...)
+	server := fake.Start()
+	t.Cleanup(server.Close)
+
+	client := ankiconnect.NewClient(ankiconnect.Config{URL: server.URL})
+	return fake, client, AnkiConnectStore{Client: client}
+}
+
+// generatedFile is the name of the audio file generated for a text field
+func generatedFile(noteID int, field, text string) string {
+	return fmt.Sprintf("%d-%s.%s.mp3", noteID, field, textFingerprint(text))
+}
+
+// writeAudio writes a local audio file that is about to be stored
+func writeAudio(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audio.mp3")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAnkiConnectStore(t *testing.T) {
+	ctx := context.Background()
+	fake, _, media := newTestCollection(t)
+
+	path := writeAudio(t, "audio of Haus")
+	if err := media.Store(ctx, path, "1-base_d.mp3"); err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+	if data, _ := fake.Media("1-base_d.mp3"); string(data) != "audio of Haus" {
+		t.Errorf("media 1-base_d.mp3 = %q, want the stored file", data)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the local file is left after it was stored: %v", err)
+	}
+
+	if err := media.Remove(ctx, "1-base_d.mp3"); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	if _, ok := fake.Media("1-base_d.mp3"); ok {
+		t.Error("the removed file is still in the media collection")
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	ctx := context.Background()
+	media := DirStore{Dir: t.TempDir()}
+
+	path := writeAudio(t, "audio of Haus")
+	if err := media.Store(ctx, path, "1-base_d.mp3"); err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(media.Dir, "1-base_d.mp3")); string(data) != "audio of Haus" {
+		t.Errorf("media 1-base_d.mp3 = %q, %v, want the stored file", data, err)
+	}
+
+	if err := media.Remove(ctx, "1-base_d.mp3"); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(media.Dir, "1-base_d.mp3")); !os.IsNotExist(err) {
+		t.Errorf("the removed file is still in the media directory: %v", err)
+	}
+}
+
+func TestAddAudioToNoteKeepsExistingAudio(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	fields := map[string]string{
+		"base_d": "Haus",
+		"base_a": "[sound:haus-aufnahme.mp3]",
+		"s1":     "&nbsp;",
+	}
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: fields})
+
+	// nothing is missing, so nothing is generated
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, Options{RemoveOldAudio: true}); err != nil {
+		t.Fatalf("AddAudioToNote() = %v", err)
+	}
+
+	note, _ := fake.Note(noteID)
+	for field, value := range fields {
+		if note.Fields[field] != value {
+			t.Errorf("%s = %q, want it unchanged", field, note.Fields[field])
+		}
+	}
+	if names := fake.MediaNames(); len(names) != 0 {
+		t.Errorf("media = %q, want none", names)
+	}
+}
+
+func TestAddAudioToNote(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{
+		"base_d": "Haus&nbsp;",
+		"s1":     "Das Haus ist alt.",
+	}})
+
+	synthesizer := &audiofake.Synthesizer{}
+	options := Options{Synthesizer: synthesizer, Voice: testVoice, WorkDir: t.TempDir()}
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+		t.Fatalf("AddAudioToNote() = %v", err)
+	}
+
+	note, _ := fake.Note(noteID)
+	for field, text := range map[string]string{"base_d": "Haus", "s1": "Das Haus ist alt."} {
+		filename := generatedFile(noteID, field, text)
+		if want := "[sound:" + filename + "]"; note.Fields[testFieldMap[field]] != want {
+			t.Errorf("%s = %q, want %q", testFieldMap[field], note.Fields[testFieldMap[field]], want)
+		}
+		if data, _ := fake.Media(filename); string(data) != audiofake.Audio(text, testVoice) {
+			t.Errorf("media %s = %q, want the audio of %q", filename, data, text)
+		}
+	}
+
+	// the audio exists, so nothing is generated again
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+		t.Fatal(err)
+	}
+	if texts := synthesizer.Texts(); len(texts) != 2 {
+		t.Errorf("synthesized %q, want each text once", texts)
+	}
+}
+
+func TestOverwriteAndRollback(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	fake.StoreMedia("haus-aufnahme.mp3", []byte("human recording"))
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{
+		"base_d": "Haus",
+		"base_a": "[sound:haus-aufnahme.mp3]",
+	}})
+
+	undoDir := t.TempDir()
+	undo, err := OpenUndo(undoDir, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := Options{
+		Overwrite:      true,
+		RemoveOldAudio: true,
+		Synthesizer:    &audiofake.Synthesizer{},
+		Voice:          testVoice,
+		WorkDir:        t.TempDir(),
+		Undo:           undo,
+	}
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+		t.Fatalf("AddAudioToNote() = %v", err)
+	}
+	if err := undo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := generatedFile(noteID, "base_d", "Haus")
+	if names := fake.MediaNames(); !slices.Equal(names, []string{generated}) {
+		t.Errorf("media after overwriting = %v, want only %s", names, generated)
+	}
+
+	if err := Rollback(ctx, client, media, undoDir, "run"); err != nil {
+		t.Fatalf("Rollback() = %v", err)
+	}
+	note, _ := fake.Note(noteID)
+	if note.Fields["base_a"] != "[sound:haus-aufnahme.mp3]" {
+		t.Errorf("base_a after Rollback() = %q", note.Fields["base_a"])
+	}
+	if data, _ := fake.Media("haus-aufnahme.mp3"); string(data) != "human recording" {
+		t.Errorf("restored recording = %q", data)
+	}
+	if names := fake.MediaNames(); !slices.Equal(names, []string{"haus-aufnahme.mp3"}) {
+		t.Errorf("media after Rollback() = %v, want only the restored recording", names)
+	}
+}
+
+func TestOnlyChanged(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus", "s1": "Das Haus ist alt."}})
+
+	synthesizer := &audiofake.Synthesizer{}
+	options := Options{Synthesizer: synthesizer, Voice: testVoice, WorkDir: t.TempDir(), OnlyChanged: true, RemoveOldAudio: true}
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UpdateNoteFields(ctx, noteID, map[string]string{"s1": "Das Haus ist neu."}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+		t.Fatalf("AddAudioToNote() after the change = %v", err)
+	}
+
+	if want := []string{"Das Haus ist alt.", "Das Haus ist neu.", "Haus"}; !slices.Equal(synthesizer.Texts(), want) {
+		t.Errorf("synthesized %q, want %q", synthesizer.Texts(), want)
+	}
+	want := []string{generatedFile(noteID, "base_d", "Haus"), generatedFile(noteID, "s1", "Das Haus ist neu.")}
+	slices.Sort(want)
+	if names := fake.MediaNames(); !slices.Equal(names, want) {
+		t.Errorf("media = %v, want %v", names, want)
+	}
+}
+
+func TestCacheReusesAudio(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	first := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+	second := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+
+	synthesizer := &audiofake.Synthesizer{}
+	options := Options{Synthesizer: synthesizer, Voice: testVoice, WorkDir: t.TempDir(), Cache: &audio.Cache{Dir: t.TempDir()}}
+	for _, noteID := range []int{first, second} {
+		if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+			t.Fatalf("AddAudioToNote(%d) = %v", noteID, err)
+		}
+		filename := generatedFile(noteID, "base_d", "Haus")
+		if data, _ := fake.Media(filename); string(data) != audiofake.Audio("Haus", testVoice) {
+			t.Errorf("media %s = %q, want the audio of Haus", filename, data)
+		}
+	}
+	if texts := synthesizer.Texts(); len(texts) != 1 {
+		t.Errorf("synthesized %q, want the text once", texts)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t, "base_a_slow", "s1a_slow")
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+
+	slow := audio.VoiceOptions{LengthScale: 1.5}
+	fast := audio.VoiceOptions{LengthScale: 0.8}
+	options := Options{
+		Synthesizer: &audiofake.Synthesizer{},
+		Voice:       testVoice,
+		WorkDir:     t.TempDir(),
+		Variants: []Variant{
+			{Name: "slow", FieldSuffix: "_slow", Voice: slow},
+			{Name: "fast", Voice: fast},
+		},
+	}
+	fieldNames := []string{"base_d", "base_a", "base_a_slow", "s1", "s1a", "s1a_slow"}
+	if err := CheckFields(fieldNames, testFieldMap, options); err != nil {
+		t.Fatalf("CheckFields() = %v", err)
+	}
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err != nil {
+		t.Fatalf("AddAudioToNote() = %v", err)
+	}
+
+	fingerprint := textFingerprint("Haus")
+	regular := generatedFile(noteID, "base_d", "Haus")
+	slowFile := fmt.Sprintf("%d-base_d-slow.%s.mp3", noteID, fingerprint)
+	fastFile := fmt.Sprintf("%d-base_d-fast.%s.mp3", noteID, fingerprint)
+
+	note, _ := fake.Note(noteID)
+	if want := "[sound:" + regular + "][sound:" + fastFile + "]"; note.Fields["base_a"] != want {
+		t.Errorf("base_a = %q, want %q", note.Fields["base_a"], want)
+	}
+	if want := "[sound:" + slowFile + "]"; note.Fields["base_a_slow"] != want {
+		t.Errorf("base_a_slow = %q, want %q", note.Fields["base_a_slow"], want)
+	}
+	if data, _ := fake.Media(slowFile); string(data) != audiofake.Audio("Haus", slow) {
+		t.Errorf("slow variant = %q, want it spoken with its own voice", data)
+	}
+
+	err := CheckFields([]string{"base_d", "base_a", "s1", "s1a"}, testFieldMap, options)
+	if err == nil || err.Error() != fmt.Sprintf("%v: base_a_slow, s1a_slow", ankiconnect.ErrFieldNotFound) {
+		t.Errorf("CheckFields() without the variant fields = %v", err)
+	}
+}
+
+func TestVariantWithoutItsField(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+
+	options := Options{
+		Synthesizer: &audiofake.Synthesizer{},
+		Variants:    []Variant{{Name: "slow", FieldSuffix: "_slow", Voice: audio.VoiceOptions{LengthScale: 1.5}}},
+	}
+	err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options)
+	if !errors.Is(err, ankiconnect.ErrFieldNotFound) {
+		t.Errorf("AddAudioToNote() without the variant field = %v, want %v", err, ankiconnect.ErrFieldNotFound)
+	}
+	if note, _ := fake.Note(noteID); note.Fields["base_a"] != "" {
+		t.Errorf("base_a = %q, want the note unchanged", note.Fields["base_a"])
+	}
+}
+
+func TestPrepareAudioWithoutCommit(t *testing.T) {
+	ctx := context.Background()
+	fake, client, _ := newTestCollection(t)
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus"}})
+
+	note, err := client.GetNote(ctx, noteID, testFieldMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir := t.TempDir()
+	options := Options{Synthesizer: &audiofake.Synthesizer{}, Voice: testVoice, WorkDir: workDir}
+	plan, err := PlanNote(note, testFieldMap, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prepared, err := PrepareAudio(ctx, plan, options)
+	if err != nil {
+		t.Fatalf("PrepareAudio() = %v", err)
+	}
+	if err := prepared.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	if names := fake.MediaNames(); len(names) != 0 {
+		t.Errorf("media = %v, want nothing stored", names)
+	}
+	if stored, _ := fake.Note(noteID); stored.Fields["base_a"] != "" {
+		t.Errorf("base_a = %q, want the note unchanged", stored.Fields["base_a"])
+	}
+	if entries, _ := os.ReadDir(workDir); len(entries) != 0 {
+		t.Errorf("work directory still has %d entries", len(entries))
+	}
+}
+
+func TestSynthesizerFailureLeavesTheNote(t *testing.T) {
+	ctx := context.Background()
+	fake, client, media := newTestCollection(t)
+	noteID := fake.AddNote(ankifake.Note{Model: "Vokabel", Fields: map[string]string{"base_d": "Haus", "s1": "Das Haus ist alt."}})
+
+	workDir := t.TempDir()
+	options := Options{
+		Synthesizer: &audiofake.Synthesizer{Fail: []string{"Das Haus ist alt."}},
+		Voice:       testVoice,
+		WorkDir:     workDir,
+	}
+	if err := AddAudioToNote(ctx, client, noteID, media, testFieldMap, options); err == nil {
+		t.Fatal("AddAudioToNote() succeeded, want the error of the synthesizer")
+	}
+
+	// the audio of the other field isn't set either, the note is updated all at once or not at all
+	if stored, _ := fake.Note(noteID); stored.Fields["base_a"] != "" || stored.Fields["s1a"] != "" {
+		t.Errorf("audio fields = %q, %q, want the note unchanged", stored.Fields["base_a"], stored.Fields["s1a"])
+	}
+	if entries, _ := os.ReadDir(workDir); len(entries) != 0 {
+		t.Errorf("work directory still has %d entries", len(entries))
+	}
+}
@@ -0,0 +1,211 @@
+package noteaudio
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/audio"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// NotePlan is everything UpdateNoteAudio would do to a note, worked out without synthesizing
+// audio or touching anki, so it can be shown to the user before anything is changed.
+type NotePlan struct {
+	NoteID int         `json:"noteId"`
+	Name   string      `json:"name"` // the base_d field, or the first text field, to recognize the note
+	Fields []FieldPlan `json:"fields,omitempty"`
+}
+
+// FieldPlan is the new audio for a single audio field
+type FieldPlan struct {
+	Field       string      `json:"field"`      // the text field the audio is generated from
+	AudioField  string      `json:"audioField"` // the field the sound tags are written to
+	Text        string      `json:"text"`       // the text that is spoken
+	Recordings  []Recording `json:"recordings"`
+	OldValue    string      `json:"oldValue"`
+	NewValue    string      `json:"newValue"`
+	RemoveFiles []string    `json:"removeFiles,omitempty"` // media files that are no longer used afterwards
+}
+
+// Recording is a single audio file generated from a text
+type Recording struct {
+	Filename string             `json:"filename"`
+	Voice    audio.VoiceOptions `json:"-"`
+}
+
+// PlanNote works out which audio fields of an already fetched note need new audio
+func PlanNote(note ankiconnect.Note, fieldMap map[string]string, options Options) (NotePlan, error) {
+	plan := NotePlan{
+		NoteID: note.NoteID,
+		Name:   normalizeFieldText(note.Phrases["base_d"].Value),
+	}
+
+	// sorted, so plans and logs are in the same order every run
+	fields := make([]string, 0, len(note.Phrases))
+	for field := range note.Phrases {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	// other note types may not have a base_d field
+	for _, field := range fields {
+		if plan.Name != "" {
+			break
+		}
+		plan.Name = normalizeFieldText(note.Phrases[field].Value)
+	}
+
+	for _, field := range fields {
+		phrase := note.Phrases[field]
+		text := normalizeFieldText(phrase.Value)
+		if text == "" {
+			continue
+		}
+
+		targets, err := audioTargets(note, field, text, fieldMap[field], options)
+		if err != nil {
+			return NotePlan{}, err
+		}
+
+		audioFields := make([]string, 0, len(targets))
+		for audioField := range targets {
+			audioFields = append(audioFields, audioField)
+		}
+		sort.Strings(audioFields)
+
+		for _, audioField := range audioFields {
+			oldValue := note.Fields[audioField]
+			if audioField == fieldMap[field] {
+				oldValue = phrase.Audio
+			}
+
+			if !needsAudio(note.NoteID, field, text, oldValue, options) {
+				// audio has already been generated
+				continue
+			}
+
+			fieldPlan := FieldPlan{
+				Field:      field,
+				AudioField: audioField,
+				Text:       text,
+				Recordings: targets[audioField],
+				OldValue:   oldValue,
+			}
+			for _, rec := range fieldPlan.Recordings {
+				fieldPlan.NewValue += fmt.Sprintf("[sound:%s]", rec.Filename)
+			}
+			if options.RemoveOldAudio {
+				fieldPlan.RemoveFiles = obsoleteFiles(oldValue, fieldPlan.NewValue)
+			}
+
+			plan.Fields = append(plan.Fields, fieldPlan)
+		}
+	}
+
+	return plan, nil
+}
+
+// CheckFields checks that a note type with the fields fieldNames has every field of fieldMap,
+// including the separate fields of variants. The error lists the missing fields and wraps ankiconnect.ErrFieldNotFound.
+func CheckFields(fieldNames []string, fieldMap map[string]string, options Options) error {
+	fields := make([]string, 0, len(fieldMap))
+	for field := range fieldMap {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var missing []string
+	for _, field := range fields {
+		audioField := fieldMap[field]
+		required := []string{field, audioField}
+		for _, variant := range options.Variants {
+			if variant.FieldSuffix != "" {
+				required = append(required, audioField+variant.FieldSuffix)
+			}
+		}
+
+		for _, name := range required {
+			if !slices.Contains(fieldNames, name) && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ankiconnect.ErrFieldNotFound, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// audioTargets returns the recordings to generate for a text field, grouped by the audio field they are written to
+func audioTargets(note ankiconnect.Note, field, text, audioField string, options Options) (map[string][]Recording, error) {
+	if _, ok := note.Fields[audioField]; !ok {
+		return nil, fmt.Errorf("note %d has no audio field %s for %s: %w", note.NoteID, audioField, field, ankiconnect.ErrFieldNotFound)
+	}
+
+	fingerprint := textFingerprint(text)
+	targets := map[string][]Recording{
+		audioField: {{
+			Filename: safeFilename(fmt.Sprintf("%d-%s.%s.mp3", note.NoteID, field, fingerprint)),
+			Voice:    options.Voice,
+		}},
+	}
+
+	for _, variant := range options.Variants {
+		rec := Recording{
+			Filename: safeFilename(fmt.Sprintf("%d-%s-%s.%s.mp3", note.NoteID, field, variant.Name, fingerprint)),
+			Voice:    variant.Voice,
+		}
+
+		if variant.FieldSuffix == "" {
+			targets[audioField] = append(targets[audioField], rec)
+			continue
+		}
+
+		variantField := audioField + variant.FieldSuffix
+		if _, ok := note.Fields[variantField]; !ok {
+			return nil, fmt.Errorf("note %d has no field %s for %s audio: %w", note.NoteID, variantField, variant.Name, ankiconnect.ErrFieldNotFound)
+		}
+		targets[variantField] = append(targets[variantField], rec)
+	}
+
+	return targets, nil
+}
+
+// safeFilename replaces characters that aren't safe in file names on every platform, e.g. from field names
+func safeFilename(name string) string {
+	return unsafeFilenameRegex.ReplaceAllString(name, "_")
+}
+
+// soundFilenames returns the filenames of all [sound:...] tags in an audio field value
+func soundFilenames(audioValue string) []string {
+	var filenames []string
+	for _, matches := range soundRegex.FindAllStringSubmatch(audioValue, -1) {
+		filenames = append(filenames, matches[1])
+	}
+	return filenames
+}
+
+// obsoleteFiles returns the files referenced by the old audio field value that the new value no longer uses
+func obsoleteFiles(oldAudioValue, newAudioValue string) []string {
+	if strings.TrimSpace(oldAudioValue) == "" {
+		return nil
+	}
+
+	oldFilenames := soundFilenames(oldAudioValue)
+	if len(oldFilenames) == 0 {
+		log.Printf("unexpected audio format: %s", oldAudioValue)
+		return nil
+	}
+
+	newFilenames := soundFilenames(newAudioValue)
+	var obsolete []string
+	for _, oldFilename := range oldFilenames {
+		if !slices.Contains(newFilenames, oldFilename) {
+			obsolete = append(obsolete, oldFilename)
+		}
+	}
+	return obsolete
+}
@@ -0,0 +1,275 @@
+package noteaudio
+
+import (
+	"anki-voice/ankiconnect"
+	"anki-voice/config"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	undoLogName    = "undo.jsonl"
+	backupMediaDir = "media"
+)
+
+// Undo keeps what a run replaces, so that the run can be rolled back with Rollback: the previous values of
+// the audio fields it changes, copies of the media files it deletes or overwrites, and the names of the files
+// it adds. Everything is kept in a directory per run, named after the run ID. A nil Undo is valid and keeps nothing.
+type Undo struct {
+	dir      string
+	mu       sync.Mutex
+	file     *os.File
+	recorded bool
+}
+
+// undoEntry is one line of a run's undo log
+type undoEntry struct {
+	NoteID  int               `json:"noteId,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`  // previous values of the fields the run changed
+	Backup  string            `json:"backup,omitempty"`  // a media file that was copied into the backup before it was replaced
+	Created string            `json:"created,omitempty"` // a media file the run added
+}
+
+// DefaultUndoDir returns the directory that keeps the undo logs and backups of all runs. It is in the user data
+// directory, because the backups may be the only copy of replaced recordings.
+func DefaultUndoDir() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backups"), nil
+}
+
+// NewRunID returns an ID for a run that starts now. The random suffix keeps runs that start in the same second apart.
+func NewRunID() string {
+	return fmt.Sprintf("%s-%04x", time.Now().Format("20060102-150405"), rand.N(0x10000))
+}
+
+// OpenUndo creates the undo directory of a run inside dir
+func OpenUndo(dir, runID string) (*Undo, error) {
+	runDir := filepath.Join(dir, runID)
+	if err := os.MkdirAll(filepath.Join(runDir, backupMediaDir), 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(runDir, undoLogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Undo{dir: runDir, file: file}, nil
+}
+
+// Close closes the undo log, and removes the run's directory when the run didn't change anything
+func (u *Undo) Close() error {
+	if u == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.file.Close(); err != nil {
+		return err
+	}
+	if !u.recorded {
+		return os.RemoveAll(u.dir)
+	}
+	return nil
+}
+
+func (u *Undo) record(entry undoEntry) error {
+	if u == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, err := u.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write undo log: %w", err)
+	}
+	u.recorded = true
+	return u.file.Sync()
+}
+
+// backupFile copies a media file into the backup before it's deleted or overwritten.
+// Only the first version of a file is kept, and a file that doesn't exist is skipped.
+func (u *Undo) backupFile(ctx context.Context, media MediaStore, filename string) error {
+	if u == nil {
+		return nil
+	}
+
+	path := filepath.Join(u.dir, backupMediaDir, filename)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	err := media.Retrieve(ctx, filename, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("back up %s: %w", filename, err)
+	}
+
+	return u.record(undoEntry{Backup: filename})
+}
+
+// backupReplaced backs up the media files of a field that generating its new recordings overwrites
+func (u *Undo) backupReplaced(ctx context.Context, media MediaStore, fieldPlan FieldPlan) error {
+	oldFiles := soundFilenames(fieldPlan.OldValue)
+	for _, rec := range fieldPlan.Recordings {
+		var err error
+		if slices.Contains(oldFiles, rec.Filename) {
+			err = u.backupFile(ctx, media, rec.Filename)
+		} else {
+			err = u.record(undoEntry{Created: rec.Filename})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UndoRuns lists the IDs of the runs that can be rolled back, oldest first
+func UndoRuns(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runIDs []string
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), undoLogName)); err == nil {
+			runIDs = append(runIDs, entry.Name())
+		}
+	}
+	sort.Strings(runIDs)
+
+	return runIDs, nil
+}
+
+// Rollback restores the field values and media files that a run replaced, and removes the media files it added.
+// It continues past failures, and returns them all at the end.
+func Rollback(ctx context.Context, client *ankiconnect.Client, media MediaStore, dir, runID string) error {
+	runDir := filepath.Join(dir, runID)
+	file, err := os.Open(filepath.Join(runDir, undoLogName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no undo log for run %s in %s", runID, dir)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// a note or file may be recorded more than once, the first record has the value from before the run
+	var noteIDs []int
+	oldFields := make(map[int]map[string]string)
+	var backups, created []string
+	backedUp := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry undoEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// the last line may be cut off when the run was killed while writing it
+			continue
+		}
+
+		switch {
+		case entry.Fields != nil:
+			if oldFields[entry.NoteID] == nil {
+				noteIDs = append(noteIDs, entry.NoteID)
+				oldFields[entry.NoteID] = make(map[string]string)
+			}
+			for field, value := range entry.Fields {
+				if _, ok := oldFields[entry.NoteID][field]; !ok {
+					oldFields[entry.NoteID][field] = value
+				}
+			}
+		case entry.Backup != "" && !backedUp[entry.Backup]:
+			backups = append(backups, entry.Backup)
+			backedUp[entry.Backup] = true
+		case entry.Created != "":
+			created = append(created, entry.Created)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, noteID := range noteIDs {
+		log.Printf("restoring %d fields of note %d", len(oldFields[noteID]), noteID)
+		err := client.UpdateNoteFields(ctx, noteID, oldFields[noteID])
+		if errors.Is(err, ankiconnect.ErrNoteNotFound) {
+			log.Printf("note %d no longer exists, skipping it", noteID)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore note %d: %w", noteID, err))
+		}
+	}
+
+	for _, filename := range backups {
+		log.Printf("restoring media file %s", filename)
+		if err := restoreFile(ctx, media, filepath.Join(runDir, backupMediaDir, filename), filename); err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", filename, err))
+		}
+	}
+
+	for _, filename := range created {
+		if backedUp[filename] {
+			continue
+		}
+		log.Printf("removing media file %s", filename)
+		err := media.Remove(ctx, filename)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("remove %s: %w", filename, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// restoreFile stores a copy of a backed up file, because storing moves the file and the backup should stay
+func restoreFile(ctx context.Context, media MediaStore, backupPath, filename string) error {
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "anki-voice-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, filename)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+
+	return media.Store(ctx, path, filename)
+}
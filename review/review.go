@@ -0,0 +1,155 @@
+// Package review keeps generated cards that wait for a human to accept, edit or reject them before they are added
+// to anki: cards staged for review, and invalid cards that were parked
+package review
+
+import (
+	"anki-voice/cardgen"
+	"anki-voice/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errEmptyWord = errors.New("review entry without a word")
+
+// Entry is a card waiting for review
+type Entry struct {
+	Word     string       `json:"word"`
+	Card     cardgen.Card `json:"card"`
+	Problems []string     `json:"problems,omitempty"` // why the card was parked, see cardgen.Validate
+	// Audio holds the preview audio of the text fields, key: field, value: file name, see Store.AudioPath
+	Audio map[string]string `json:"audio,omitempty"`
+	// NoteID is the note of a card that was added to anki, but whose audio failed
+	NoteID  int       `json:"noteId,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Store keeps one JSON file per word in Dir, and the audio of the word in a directory next to it
+type Store struct {
+	Dir string
+}
+
+// DefaultDir returns the review directory in the user data directory, see config.DataDir. The vocab file of a word
+// is deleted once its card is in the review directory, so the card must not be removed like a cache.
+func DefaultDir() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "review"), nil
+}
+
+// Save stores an entry, replacing an earlier entry of the same word. The audio files have to be written first.
+func (s *Store) Save(entry Entry) error {
+	if err := checkWord(entry.Word); err != nil {
+		return err
+	}
+	if entry.Created.IsZero() {
+		entry.Created = time.Now()
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(entry.Word), data, 0o644)
+}
+
+// List returns the entries in the order they were added
+func (s *Store) List() ([]Entry, error) {
+	files, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("review entry %s: %w", file.Name(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// Remove deletes the entry of a word and its audio
+func (s *Store) Remove(word string) error {
+	if err := checkWord(word); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.audioDir(word)); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(word))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// AudioPath returns the path of an audio file of a word's entry, in the directory created by ResetAudio
+func (s *Store) AudioPath(word, filename string) string {
+	return filepath.Join(s.audioDir(word), filename)
+}
+
+// ResetAudio removes the audio of a word's entry, e.g. before the audio of an edited card is written,
+// and creates its empty audio directory
+func (s *Store) ResetAudio(word string) error {
+	if err := checkWord(word); err != nil {
+		return err
+	}
+	dir := s.audioDir(word)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+func (s *Store) audioDir(word string) string {
+	return filepath.Join(s.Dir, fileName(word))
+}
+
+// path returns the file of a word
+func (s *Store) path(word string) string {
+	return filepath.Join(s.Dir, fileName(word)+".json")
+}
+
+// fileName is the name of a word's file and audio directory. The word is escaped, since it comes from a model or
+// a vocab file, and so are its dots, so that a word like ".." never names the store or the directory above it.
+func fileName(word string) string {
+	return strings.ReplaceAll(url.PathEscape(word), ".", "%2E")
+}
+
+// checkWord rejects the empty word, whose audio directory would be the store itself
+func checkWord(word string) error {
+	if strings.TrimSpace(word) == "" {
+		return errEmptyWord
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package review
+
+import (
+	"anki-voice/cardgen"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreKeepsWordsInsideTheStore(t *testing.T) {
+	dataDir := t.TempDir()
+	backup := filepath.Join(dataDir, "backups", "run", "undo.jsonl")
+	if err := os.MkdirAll(filepath.Dir(backup), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backup, []byte("{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{Dir: filepath.Join(dataDir, "review")}
+	if err := store.Save(Entry{Word: "Haus", Card: cardgen.Card{BaseDeutsch: "Haus"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, word := range []string{"..", ".", "../backups", ".hidden"} {
+		if err := store.ResetAudio(word); err != nil {
+			t.Fatalf("ResetAudio(%q) = %v", word, err)
+		}
+		if dir := filepath.Dir(store.AudioPath(word, "s1.mp3")); filepath.Dir(dir) != store.Dir {
+			t.Errorf("audio directory of %q = %s, want it inside %s", word, dir, store.Dir)
+		}
+		if err := store.Save(Entry{Word: word}); err != nil {
+			t.Fatalf("Save(%q) = %v", word, err)
+		}
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("the backups next to the store are gone: %v", err)
+	}
+
+	entries, err := store.List()
+	if err != nil || len(entries) != 5 {
+		t.Fatalf("List() = %d entries, %v, want 5", len(entries), err)
+	}
+	for _, word := range []string{"..", ".", "../backups", ".hidden"} {
+		if err := store.Remove(word); err != nil {
+			t.Errorf("Remove(%q) = %v", word, err)
+		}
+	}
+	if entries, _ := store.List(); len(entries) != 1 || entries[0].Word != "Haus" {
+		t.Errorf("List() after removing = %+v, want only Haus", entries)
+	}
+
+	if err := store.ResetAudio(" "); err == nil {
+		t.Error("ResetAudio() of an empty word succeeded, want an error")
+	}
+	if err := store.Save(Entry{}); err == nil {
+		t.Error("Save() of an entry without a word succeeded, want an error")
+	}
+}